@@ -7,6 +7,7 @@ require (
 	github.com/pkg/errors v0.9.1
 	github.com/ssor/bom v0.0.0-20170718123548-6386211fdfcf
 	golang.org/x/net v0.10.0
+	golang.org/x/text v0.9.0
 )
 
 require github.com/mattn/go-runewidth v0.0.9 // indirect
@@ -1,27 +1,108 @@
 package html2text
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"encoding/binary"
 	"io"
+	"math"
+	"net/url"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
 
 	"github.com/olekukonko/tablewriter"
 	"github.com/pkg/errors"
 	"github.com/ssor/bom"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
 )
 
 // Options provide toggles and overrides to control specific rendering behaviors.
 type Options struct {
-	PrettyTables        bool                 // Turns on pretty ASCII rendering for table elements.
-	PrettyTablesOptions *PrettyTablesOptions // Configures pretty ASCII rendering for table elements.
-	OmitLinks           bool                 // Turns on omitting links
-	TextOnly            bool                 // Returns only plain text
+	PrettyTables           bool                      // Turns on pretty ASCII rendering for table elements.
+	PrettyTablesOptions    *PrettyTablesOptions      // Configures pretty ASCII rendering for table elements.
+	OmitLinks              bool                      // Turns on omitting links
+	TextOnly               bool                      // Returns only plain text
+	ListBullet             string                    // Marker for unordered list items, "*" when empty
+	IncludeImageSrc        bool                      // Appends image src and dimensions after its alt text
+	LineWidth              int                       // Width long lines are broken at, 74 when zero
+	UnicodeSupSub          bool                      // Renders sup and sub elements with Unicode characters when possible
+	NodeHandlers           map[atom.Atom]NodeHandler // Overrides the rendering of specific elements
+	LinkFootnotes          bool                      // Collects links as numbered references at the end
+	HeadingUnderlines      [6]string                 // Divider characters of headings h1 to h6, defaults when empty
+	ExpandAbbreviations    bool                      // Follows abbreviations with their title
+	UnicodeQuotes          bool                      // Renders q elements with curly instead of straight quotes
+	BlockquotePrefix       string                    // Marker quoted lines are prefixed with per level, ">" when empty
+	MaxLinkLength          int                       // Shortens displayed links longer than this, disabled when zero
+	NormalizeSpaces        bool                      // Turns non-breaking spaces into spaces and strips zero-width characters
+	TrailingNewline        bool                      // Ends non-empty output with a newline
+	Markdown               bool                      // Renders Markdown constructs such as fenced code blocks
+	FigurePrefix           string                    // Prefix of figure captions, "Figure: " when empty
+	MaxConsecutiveNewlines int                       // Caps runs of newlines in the output, 2 when zero
+	PreferLinkTitle        bool                      // Shows the title of links instead of their href when present
+	PreserveWhitespace     bool                      // Keeps the spacing of all text as if it were preformatted
+	MarkDelimiter          string                    // Surrounds highlighted text, "==" when empty
+	AutolinkBareURLs       bool                      // Wraps URLs found in text outside links as <url> Markdown autolinks
+	WrapText               bool                      // Breaks long lines outside blockquotes too, except in preformatted text
+	EmojiMode              EmojiMode                 // Keeps, strips or converts emoji in text to shortcodes
+	PlainTableSeparator    string                    // Separates table cells when PrettyTables is off, "\t" when empty
+	OmitLinkDomains        []string                  // Omits links to these domains and their subdomains, keeping the link text
+	ShowTimeDatetime       bool                      // Follows time elements with their datetime attribute
+	SkipSelectors          []string                  // Skips elements matching any of these .class or #id selectors
+	MaxDepth               int                       // Element nesting depth rendering fails at, 1000 when zero, unlimited when negative
+	BracketLinkText        bool                      // Wraps link text in brackets, always followed by the href
+	RenderFormControls     bool                      // Renders the value or placeholder of input elements, the state of checkboxes and radio buttons, the selected option of dropdowns and gauges
+	ListSelectOptions      bool                      // Lists all the options of dropdowns when rendering form controls
+	LineEnding             string                    // Ends output lines, "\n" when empty
+	OmitFragmentLinks      bool                      // Omits links to fragments of the page, keeping the link text
+	KeepMailtoPrefix       bool                      // Keeps the mailto: scheme of email links
+	StripTelPrefix         bool                      // Strips the tel: scheme of phone links
+	ClassStyleMap          map[string]string         // Surrounds the text of span elements having one of these classes with its wrapper
+	IncludeTitle           bool                      // Starts the output with the document title as a top level heading
+	IncludeMetaDescription bool                      // Starts the output with the document description as a paragraph, after the title
+	SkipNav                bool                      // Leaves out the content of nav elements
+	SkipNoscript           bool                      // Leaves out the content of noscript elements
+	TextTransform          func(string) string       // Transforms the text of every text node before rendering
+	RenderIframes          bool                      // Renders iframes as a reference to their src
+	RenderMedia            bool                      // Renders video and audio elements as a reference to their source
+	AllowedAtoms           []atom.Atom               // Only renders these elements when not empty, rendering the content of others as plain text
+	SkipDisallowedAtoms    bool                      // Leaves out the content of elements not in AllowedAtoms
+	BaseURL                string                    // Resolves relative links against this URL, overridden by the document base element
+	UppercaseHeadings      bool                      // Uppercases headings in TextOnly mode
+	ShowLinkRel            bool                      // Follows rendered links with their rel attribute, such as [nofollow]
+	Template               *template.Template        // Renders the document as this template executed with each of its Blocks
+	LinkNormalizer         func(string) string       // Normalizes link hrefs instead of the built-in trimming, resolving and scheme stripping
+	StripEmoji             bool                      // Strips emoji and other symbols such as the copyright sign from text, regardless of EmojiMode
+	DropEmptyBlocks        bool                      // Leaves out paragraphs and other blocks with no text, along with their spacing
 }
 
+// EmojiMode controls how emoji found in text are rendered.
+type EmojiMode int
+
+const (
+	EmojiKeep      EmojiMode = iota // Leaves emoji as they are
+	EmojiStrip                      // Removes emoji
+	EmojiShortcode                  // Replaces known emoji with their :name: shortcode
+)
+
+// ErrMaxDepthExceeded is returned when the elements of the document are nested
+// deeper than Options.MaxDepth.
+var ErrMaxDepthExceeded = errors.New("html2text: maximum element nesting depth exceeded")
+
+// NodeHandler renders an element from its node and the text rendered from its
+// children. Handlers are passed along the Options of each call, so they only
+// apply to that call.
+type NodeHandler func(node *html.Node, content string) (string, error)
+
 // PrettyTablesOptions overrides tablewriter behaviors
 type PrettyTablesOptions struct {
 	AutoFormatHeader     bool
@@ -40,6 +121,8 @@ type PrettyTablesOptions struct {
 	RowLine              bool
 	AutoMergeCells       bool
 	Borders              tablewriter.Border
+	MaxTableWidth        int  // Maximum width of the rendered grid, 0 for no limit.
+	PreserveCellNewlines bool // Keeps the line breaks of cells, wrapping each line apart.
 }
 
 // NewPrettyTablesOptions creates PrettyTablesOptions with default settings
@@ -64,31 +147,155 @@ func NewPrettyTablesOptions() *PrettyTablesOptions {
 	}
 }
 
+// Clone returns a deep copy of the options, which can be customized without
+// affecting the original. Rendering never modifies its options, so the same
+// Options may be shared by concurrent calls as long as nobody changes them.
+func (o Options) Clone() Options {
+	clone := o
+	if o.PrettyTablesOptions != nil {
+		prettyTablesOptions := *o.PrettyTablesOptions
+		if o.PrettyTablesOptions.ColumnAlignment != nil {
+			prettyTablesOptions.ColumnAlignment = append([]int{}, o.PrettyTablesOptions.ColumnAlignment...)
+		}
+		clone.PrettyTablesOptions = &prettyTablesOptions
+	}
+	if o.NodeHandlers != nil {
+		clone.NodeHandlers = make(map[atom.Atom]NodeHandler, len(o.NodeHandlers))
+		for a, handler := range o.NodeHandlers {
+			clone.NodeHandlers[a] = handler
+		}
+	}
+	if o.OmitLinkDomains != nil {
+		clone.OmitLinkDomains = append([]string{}, o.OmitLinkDomains...)
+	}
+	if o.SkipSelectors != nil {
+		clone.SkipSelectors = append([]string{}, o.SkipSelectors...)
+	}
+	if o.AllowedAtoms != nil {
+		clone.AllowedAtoms = append([]atom.Atom{}, o.AllowedAtoms...)
+	}
+	if o.ClassStyleMap != nil {
+		clone.ClassStyleMap = make(map[string]string, len(o.ClassStyleMap))
+		for class, wrapper := range o.ClassStyleMap {
+			clone.ClassStyleMap[class] = wrapper
+		}
+	}
+	return clone
+}
+
 // FromHTMLNode renders text output from a pre-parsed HTML document.
 func FromHTMLNode(doc *html.Node, o ...Options) (string, error) {
+	return fromHTMLNode(context.Background(), doc, o...)
+}
+
+func fromHTMLNode(cancelCtx context.Context, doc *html.Node, o ...Options) (string, error) {
+	text, _, err := fromHTMLNodeWithStats(cancelCtx, doc, o...)
+	return text, err
+}
+
+// fromHTMLNodeWithStats renders the document, also reporting statistics about
+// the rendering.
+func fromHTMLNodeWithStats(cancelCtx context.Context, doc *html.Node, o ...Options) (string, Stats, error) {
 	var options Options
 	if len(o) > 0 {
 		options = o[0]
 	}
 
+	stats := Stats{}
 	ctx := textifyTraverseContext{
-		buf:     bytes.Buffer{},
-		options: options,
+		buf:       bufferPool.Get().(*bytes.Buffer),
+		visited:   new(int),
+		options:   options,
+		cancelCtx: cancelCtx,
+		footnotes: &[]string{},
+		stats:     &stats,
 	}
-	if err := ctx.traverse(doc); err != nil {
-		return "", err
+	defer ctx.release()
+	var text string
+	if options.Template != nil {
+		if err := ctx.executeTemplate(doc); err != nil {
+			return "", Stats{}, err
+		}
+		// The template has full control over the output spacing.
+		text = ctx.buf.String()
+	} else {
+		if err := ctx.traverse(doc); err != nil {
+			return "", Stats{}, err
+		}
+		text = ctx.text()
 	}
+	text = ctx.finish(text)
+	if text != "" {
+		for _, line := range strings.Split(strings.TrimSuffix(text, "\n"), "\n") {
+			stats.Lines++
+			if width := len([]rune(line)); width > stats.MaxLineWidth {
+				stats.MaxLineWidth = width
+			}
+		}
+	}
+	return ctx.withLineEnding(text), stats, nil
+}
 
-	text := strings.TrimSpace(newlineRe.ReplaceAllString(
-		strings.Replace(ctx.buf.String(), "\n ", "\n", -1), "\n\n"),
-	)
-	return text, nil
+// finish ends the rendered text with the footnotes and the trailing newline.
+func (ctx *textifyTraverseContext) finish(text string) string {
+	if len(*ctx.footnotes) > 0 {
+		refs := make([]string, len(*ctx.footnotes))
+		for i, link := range *ctx.footnotes {
+			refs[i] = "[" + strconv.Itoa(i+1) + "] " + link
+		}
+		text = strings.TrimRightFunc(text, unicode.IsSpace) + "\n\n" + strings.Join(refs, "\n")
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+		if ctx.flushed == 0 {
+			text = strings.TrimSpace(text)
+		}
+	}
+	if ctx.options.TrailingNewline && (text != "" || ctx.flushed > 0) {
+		text += "\n"
+	}
+	return text
+}
+
+// withLineEnding returns text with the line endings of the options.
+func (ctx *textifyTraverseContext) withLineEnding(text string) string {
+	if lineEnding := ctx.options.LineEnding; lineEnding != "" && lineEnding != "\n" {
+		return strings.ReplaceAll(text, "\n", lineEnding)
+	}
+	return text
+}
+
+// Stats describes the text rendered from an HTML document.
+type Stats struct {
+	Lines        int // Number of lines of the text
+	MaxLineWidth int // Number of runes of the longest line
+	LinkCount    int // Number of links with an href
+	TableCount   int // Number of tables
+}
+
+// FromStringWithStats parses HTML from the input string, then renders the text
+// form along with statistics about it.
+func FromStringWithStats(input string, options ...Options) (string, Stats, error) {
+	reader, err := newReaderWithoutBom(strings.NewReader(input))
+	if err != nil {
+		return "", Stats{}, err
+	}
+	doc, err := html.Parse(reader)
+	if err != nil {
+		return "", Stats{}, err
+	}
+	return fromHTMLNodeWithStats(context.Background(), doc, options...)
 }
 
 // FromReader renders text output after parsing HTML for the specified
 // io.Reader.
 func FromReader(reader io.Reader, options ...Options) (string, error) {
-	newReader, err := bom.NewReaderWithoutBom(reader)
+	return FromReaderWithContext(context.Background(), reader, options...)
+}
+
+// FromReaderWithContext renders text output after parsing HTML for the
+// specified io.Reader, giving up with the context error as soon as the
+// context is done.
+func FromReaderWithContext(cancelCtx context.Context, reader io.Reader, options ...Options) (string, error) {
+	newReader, err := newReaderWithoutBom(reader)
 	if err != nil {
 		return "", err
 	}
@@ -96,7 +303,130 @@ func FromReader(reader io.Reader, options ...Options) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	return FromHTMLNode(doc, options...)
+	if err := cancelCtx.Err(); err != nil {
+		return "", err
+	}
+	return fromHTMLNode(cancelCtx, doc, options...)
+}
+
+// newReaderWithoutBom returns a reader skipping the byte order mark at the
+// start of the reader, whatever the size of the chunks it reads. Text starting
+// with a UTF-16 byte order mark is decoded to UTF-8.
+func newReaderWithoutBom(reader io.Reader) (io.Reader, error) {
+	bufReader := bufio.NewReader(reader)
+	// Peek blocks until enough bytes are read, or the reader is exhausted.
+	start, err := bufReader.Peek(3)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case bytes.HasPrefix(start, []byte{0xEF, 0xBB, 0xBF}):
+		_, err = bufReader.Discard(3)
+		return bufReader, err
+	case bytes.HasPrefix(start, []byte{0xFF, 0xFE}):
+		_, err = bufReader.Discard(2)
+		return &utf16Reader{reader: bufReader, order: binary.LittleEndian}, err
+	case bytes.HasPrefix(start, []byte{0xFE, 0xFF}):
+		_, err = bufReader.Discard(2)
+		return &utf16Reader{reader: bufReader, order: binary.BigEndian}, err
+	}
+	return bufReader, nil
+}
+
+// utf16Reader decodes UTF-16 text into UTF-8.
+type utf16Reader struct {
+	reader  *bufio.Reader
+	order   binary.ByteOrder
+	pending []byte
+}
+
+func (u *utf16Reader) Read(p []byte) (int, error) {
+	n := copy(p, u.pending)
+	u.pending = u.pending[n:]
+	for n < len(p) {
+		r, err := u.readRune()
+		if err != nil {
+			if n > 0 {
+				return n, nil
+			}
+			return 0, err
+		}
+		var buf [utf8.UTFMax]byte
+		size := utf8.EncodeRune(buf[:], r)
+		copied := copy(p[n:], buf[:size])
+		// Keep what doesn't fit for the next read.
+		u.pending = append(u.pending, buf[copied:size]...)
+		n += copied
+	}
+	return n, nil
+}
+
+// readRune decodes the next rune, replacing unpaired surrogates with
+// utf8.RuneError. A trailing odd byte is dropped.
+func (u *utf16Reader) readRune() (rune, error) {
+	var unit [2]byte
+	if _, err := io.ReadFull(u.reader, unit[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return 0, io.EOF
+		}
+		return 0, err
+	}
+	r1 := rune(u.order.Uint16(unit[:]))
+	if !utf16.IsSurrogate(r1) {
+		return r1, nil
+	}
+	next, _ := u.reader.Peek(2)
+	if len(next) < 2 {
+		return utf8.RuneError, nil
+	}
+	r := utf16.DecodeRune(r1, rune(u.order.Uint16(next)))
+	if r != utf8.RuneError {
+		_, _ = u.reader.Discard(2)
+	}
+	return r, nil
+}
+
+// FromReaderToWriter renders text output after parsing HTML for the specified
+// io.Reader and writes it to the specified io.Writer as it is rendered, rather
+// than holding the whole text in memory. The output is the one of FromReader,
+// only partially written on errors.
+func FromReaderToWriter(reader io.Reader, writer io.Writer, options ...Options) error {
+	newReader, err := newReaderWithoutBom(reader)
+	if err != nil {
+		return err
+	}
+	doc, err := html.Parse(newReader)
+	if err != nil {
+		return err
+	}
+	var opts Options
+	if len(options) > 0 {
+		opts = options[0]
+	}
+	// Templates see the whole document at once, and footnotes trim the
+	// indentation preserved at the beginning of the text.
+	if opts.Template != nil || opts.PreserveWhitespace && opts.LinkFootnotes {
+		text, err := fromHTMLNode(context.Background(), doc, opts)
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(writer, text)
+		return err
+	}
+
+	ctx := textifyTraverseContext{
+		buf:       bufferPool.Get().(*bytes.Buffer),
+		visited:   new(int),
+		options:   opts,
+		footnotes: &[]string{},
+		out:       writer,
+	}
+	defer ctx.release()
+	if err := ctx.traverse(doc); err != nil {
+		return err
+	}
+	_, err = io.WriteString(writer, ctx.withLineEnding(ctx.finish(ctx.text())))
+	return err
 }
 
 // FromString parses HTML from the input string, then renders the text form.
@@ -109,15 +439,441 @@ func FromString(input string, options ...Options) (string, error) {
 	return text, nil
 }
 
+// Table holds the text of the cells of an HTML table. Footer holds the last
+// footer row, the previous ones ending the Body.
+type Table struct {
+	Header []string
+	Body   [][]string
+	Footer []string
+}
+
+// TablesFromHTMLNode extracts the tables of a pre-parsed HTML document, in
+// document order.
+func TablesFromHTMLNode(doc *html.Node) ([]Table, error) {
+	tables := []Table{}
+	ctx := textifyTraverseContext{
+		buf:       bufferPool.Get().(*bytes.Buffer),
+		visited:   new(int),
+		options:   Options{PrettyTables: true},
+		cancelCtx: context.Background(),
+		footnotes: &[]string{},
+		tables:    &tables,
+	}
+	defer ctx.release()
+	if err := ctx.traverse(doc); err != nil {
+		return nil, err
+	}
+	return tables, nil
+}
+
+// BlockType is the kind of a Block.
+type BlockType string
+
+// Block types.
+const (
+	BlockHeading      BlockType = "heading"
+	BlockParagraph    BlockType = "paragraph"
+	BlockPreformatted BlockType = "preformatted"
+	BlockList         BlockType = "list"
+	BlockTable        BlockType = "table"
+	BlockBlockquote   BlockType = "blockquote"
+)
+
+// Block is a block of a document along with its rendered text.
+type Block struct {
+	Type     BlockType `json:"type"`
+	Level    int       `json:"level,omitempty"`    // Level of headings, nesting level of blockquotes
+	Text     string    `json:"text"`               // Text of the block, without heading dividers or its own quote prefix
+	Children []Block   `json:"children,omitempty"` // Blocks quoted by blockquotes
+}
+
+// StructuredFromHTMLNode splits a pre-parsed HTML document into headings,
+// paragraphs, preformatted text, lists, tables and blockquotes, in document
+// order. Other content, such as text outside of paragraphs or address, figure
+// and details elements, makes up paragraphs too.
+func StructuredFromHTMLNode(doc *html.Node, o ...Options) ([]Block, error) {
+	var options Options
+	if len(o) > 0 {
+		options = o[0]
+	}
+
+	ctx := textifyTraverseContext{
+		buf:       bufferPool.Get().(*bytes.Buffer),
+		visited:   new(int),
+		options:   options,
+		cancelCtx: context.Background(),
+		footnotes: &[]string{},
+	}
+	defer ctx.release()
+	blocks := []Block{}
+	if err := ctx.collectBlocks(doc, 0, &blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
+
+// executeTemplate renders the blocks of the document with the Template option,
+// executed once per block.
+func (ctx *textifyTraverseContext) executeTemplate(doc *html.Node) error {
+	blocks := []Block{}
+	if err := ctx.collectBlocks(doc, 0, &blocks); err != nil {
+		return err
+	}
+	for _, block := range blocks {
+		if err := ctx.options.Template.Execute(ctx.buf, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectBlocks appends the blocks found among the descendants of node,
+// quoteLevel being the number of blockquotes they are in.
+func (ctx *textifyTraverseContext) collectBlocks(node *html.Node, quoteLevel int, blocks *[]Block) error {
+	// Runs of text and inline elements between blocks make up paragraphs.
+	runCtx := ctx.blockContext()
+	endRun := func() {
+		if text := runCtx.text(); text != "" {
+			*blocks = append(*blocks, Block{Type: BlockParagraph, Text: text})
+		}
+		runCtx.release()
+		runCtx = ctx.blockContext()
+	}
+	defer func() {
+		endRun()
+		runCtx.release()
+	}()
+
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.ElementNode && c.DataAtom == atom.Head:
+			endRun()
+			if err := ctx.collectHeadBlocks(c, blocks); err != nil {
+				return err
+			}
+			// The base URL of the head applies to the runs of text too.
+			runCtx.release()
+			runCtx = ctx.blockContext()
+			continue
+		case c.Type == html.ElementNode && (c.DataAtom == atom.Script || c.DataAtom == atom.Style):
+			continue
+		case c.Type != html.ElementNode || !blockElements[c.DataAtom] || c.DataAtom == atom.Br:
+			if err := runCtx.traverse(c); err != nil {
+				return err
+			}
+			continue
+		case isHidden(c) || ctx.isSkipped(c) || (c.DataAtom == atom.Nav && ctx.options.SkipNav):
+			continue
+		}
+		endRun()
+
+		var (
+			block        Block
+			subCtx       = ctx.blockContext()
+			preformatted bool
+			err          error
+		)
+		switch c.DataAtom {
+		case atom.Hr:
+			subCtx.release()
+			continue
+		case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+			block = Block{Type: BlockHeading, Level: headingLevels[c.DataAtom]}
+			err = subCtx.traverseChildren(c)
+		case atom.P:
+			block = Block{Type: BlockParagraph}
+			err = subCtx.traverseChildren(c)
+		case atom.Ul, atom.Ol, atom.Dl:
+			block = Block{Type: BlockList}
+			err = subCtx.traverse(c)
+		case atom.Table:
+			block = Block{Type: BlockTable}
+			err = subCtx.traverse(c)
+		case atom.Pre:
+			block = Block{Type: BlockPreformatted}
+			err = subCtx.traverse(c)
+			preformatted = true
+		case atom.Address, atom.Figure, atom.Details:
+			block = Block{Type: BlockParagraph}
+			err = subCtx.traverse(c)
+		case atom.Blockquote:
+			block = Block{Type: BlockBlockquote, Level: quoteLevel + 1}
+			if err = subCtx.traverseChildren(c); err == nil {
+				// The quoted content was already accounted for by the text.
+				childrenCtx := ctx.blockContext()
+				childrenCtx.stats = nil
+				err = childrenCtx.collectBlocks(c, quoteLevel+1, &block.Children)
+				childrenCtx.release()
+			}
+		default:
+			subCtx.release()
+			ctx.depth++
+			if maxDepth := ctx.maxDepth(); maxDepth > 0 && ctx.depth > maxDepth {
+				return ErrMaxDepthExceeded
+			}
+			err := ctx.collectBlocks(c, quoteLevel, blocks)
+			ctx.depth--
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if preformatted {
+			// Keep the indentation of the first line.
+			block.Text = trimBlankLines(subCtx.buf.String())
+		} else {
+			block.Text = subCtx.text()
+		}
+		subCtx.release()
+		if block.Text != "" {
+			*blocks = append(*blocks, block)
+		}
+	}
+	return nil
+}
+
+// collectHeadBlocks appends the title and the description of the document
+// as a heading and a paragraph, when included, picking up its base URL.
+func (ctx *textifyTraverseContext) collectHeadBlocks(node *html.Node, blocks *[]Block) error {
+	title, description := ctx.headInfo(node)
+	if ctx.options.IncludeTitle && title != nil {
+		subCtx := ctx.subContext()
+		defer subCtx.release()
+		if err := subCtx.traverseChildren(title); err != nil {
+			return err
+		}
+		if text := subCtx.text(); text != "" {
+			*blocks = append(*blocks, Block{Type: BlockHeading, Level: 1, Text: text})
+		}
+	}
+	if ctx.options.IncludeMetaDescription && description != "" {
+		*blocks = append(*blocks, Block{Type: BlockParagraph, Text: description})
+	}
+	return nil
+}
+
+// Regular expressions run on every text node, so they are compiled once here
+// rather than by the options using them.
 var (
 	spacingRe = regexp.MustCompile(`[ \r\n\t]+`)
-	newlineRe = regexp.MustCompile(`\n\n+`)
+	// bareURLRe matches URLs in text, leaving out trailing punctuation.
+	bareURLRe = regexp.MustCompile(`https?://[^\s<>"]*[^\s<>".,;:!?)\]']`)
+)
+
+// emojiShortcodes maps common emoji to their shortcode names.
+var emojiShortcodes = map[rune]string{
+	'\u263A':     "relaxed",
+	'\u2600':     "sunny",
+	'\u2601':     "cloud",
+	'\u2614':     "umbrella",
+	'\u26A0':     "warning",
+	'\u26A1':     "zap",
+	'\u2705':     "white_check_mark",
+	'\u2708':     "airplane",
+	'\u2709':     "envelope",
+	'\u270C':     "v",
+	'\u2714':     "heavy_check_mark",
+	'\u2728':     "sparkles",
+	'\u274C':     "x",
+	'\u2753':     "question",
+	'\u2757':     "exclamation",
+	'\u2764':     "heart",
+	'\u2B50':     "star",
+	'\U0001F308': "rainbow",
+	'\U0001F381': "gift",
+	'\U0001F382': "birthday",
+	'\U0001F389': "tada",
+	'\U0001F3C6': "trophy",
+	'\U0001F440': "eyes",
+	'\U0001F44B': "wave",
+	'\U0001F44C': "ok_hand",
+	'\U0001F44D': "+1",
+	'\U0001F44E': "-1",
+	'\U0001F44F': "clap",
+	'\U0001F494': "broken_heart",
+	'\U0001F4A1': "bulb",
+	'\U0001F4AF': "100",
+	'\U0001F4E7': "email",
+	'\U0001F525': "fire",
+	'\U0001F600': "grinning",
+	'\U0001F601': "grin",
+	'\U0001F602': "joy",
+	'\U0001F603': "smiley",
+	'\U0001F604': "smile",
+	'\U0001F605': "sweat_smile",
+	'\U0001F609': "wink",
+	'\U0001F60A': "blush",
+	'\U0001F60D': "heart_eyes",
+	'\U0001F60E': "sunglasses",
+	'\U0001F610': "neutral_face",
+	'\U0001F61B': "stuck_out_tongue",
+	'\U0001F622': "cry",
+	'\U0001F62D': "sob",
+	'\U0001F631': "scream",
+	'\U0001F642': "slightly_smiling_face",
+	'\U0001F64F': "pray",
+	'\U0001F680': "rocket",
+	'\U0001F914': "thinking",
+	'\U0001F923': "rofl",
+}
+
+// isEmoji reports whether r is an emoji, an emoji modifier or a combining
+// keycap.
+func isEmoji(r rune) bool {
+	switch {
+	case r >= 0x1F000 && r <= 0x1FAFF, // Pictographs, emoticons, flags and modifiers.
+		r >= 0x2600 && r <= 0x27BF, // Miscellaneous symbols and dingbats.
+		r >= 0x2B00 && r <= 0x2BFF, // Arrows, stars and shapes.
+		r == 0x20E3:                // Combining keycap.
+		return true
+	}
+	return false
+}
+
+// isEmojiJoiner reports whether r is a zero-width joiner or a variation
+// selector, which join emoji sequences and select their presentation, but
+// also shape the text of other scripts.
+func isEmojiJoiner(r rune) bool {
+	return r == 0x200D || r >= 0xFE00 && r <= 0xFE0F
+}
+
+// walkEmoji calls fn with each rune of text, reporting whether it belongs to
+// an emoji. Joiners only do when next to an emoji.
+func walkEmoji(text string, fn func(r rune, emoji bool)) {
+	prevEmoji := false
+	for i, r := range text {
+		emoji := isEmoji(r)
+		if !emoji && isEmojiJoiner(r) {
+			next, _ := utf8.DecodeRuneInString(text[i+utf8.RuneLen(r):])
+			emoji = prevEmoji || isEmoji(next)
+		}
+		fn(r, emoji)
+		prevEmoji = emoji
+	}
+}
+
+// stripSymbols drops the emoji and other symbols of text.
+func stripSymbols(text string) string {
+	var buf strings.Builder
+	walkEmoji(text, func(r rune, emoji bool) {
+		if !emoji && !unicode.Is(unicode.So, r) {
+			buf.WriteRune(r)
+		}
+	})
+	return buf.String()
+}
+
+// replaceEmoji renders the emoji of text according to mode.
+func replaceEmoji(text string, mode EmojiMode) string {
+	if mode == EmojiKeep {
+		return text
+	}
+	var (
+		buf       strings.Builder
+		converted bool
+	)
+	walkEmoji(text, func(r rune, emoji bool) {
+		switch {
+		case !emoji:
+			buf.WriteRune(r)
+			converted = false
+		case mode == EmojiShortcode && emojiShortcodes[r] != "":
+			buf.WriteString(":" + emojiShortcodes[r] + ":")
+			converted = true
+		case mode == EmojiShortcode && converted && (r >= 0xFE00 && r <= 0xFE0F || r >= 0x1F3FB && r <= 0x1F3FF):
+			// Drop the presentation selectors and skin tone modifiers
+			// trailing converted emoji.
+		case mode == EmojiShortcode:
+			buf.WriteRune(r)
+			converted = false
+		}
+	})
+	return buf.String()
+}
+
+// spaceNormalizer turns non-breaking spaces into regular ones and drops
+// zero-width characters.
+var spaceNormalizer = strings.NewReplacer(
+	"\u00A0", " ",
+	"\u200B", "",
+	"\u200C", "",
+	"\u200D", "",
+	"\u2060", "",
+	"\uFEFF", "",
 )
 
+// headingDividers holds the default divider character of each heading level,
+// getting lighter as the level decreases in importance.
+var headingDividers = [6]string{"*", "-", "-", "~", "^", "."}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1,
+	atom.H2: 2,
+	atom.H3: 3,
+	atom.H4: 4,
+	atom.H5: 5,
+	atom.H6: 6,
+}
+
+// blockElements are the elements whose boundaries end sentences in TextOnly
+// mode.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Body: true, atom.Br: true, atom.Caption: true, atom.Dd: true,
+	atom.Details: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.H1: true,
+	atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true,
+	atom.H6: true, atom.Header: true, atom.Hr: true, atom.Html: true,
+	atom.Li: true, atom.Main: true, atom.Nav: true, atom.Ol: true,
+	atom.P: true, atom.Pre: true, atom.Section: true, atom.Summary: true,
+	atom.Table: true, atom.Td: true, atom.Th: true, atom.Tr: true,
+	atom.Ul: true,
+}
+
+// endsAtBlockBoundary reports whether only whitespace separates the end of the
+// node from the boundary of a block element.
+func endsAtBlockBoundary(node *html.Node) bool {
+	for n := node; n.Parent != nil; n = n.Parent {
+		for s := n.NextSibling; s != nil; s = s.NextSibling {
+			if s.Type == html.CommentNode || (s.Type == html.TextNode && strings.TrimSpace(s.Data) == "") {
+				continue
+			}
+			return s.Type == html.ElementNode && blockElements[s.DataAtom]
+		}
+		if blockElements[n.Parent.DataAtom] {
+			return true
+		}
+	}
+	return true
+}
+
 // traverseTableCtx holds text-related context.
 type textifyTraverseContext struct {
-	buf bytes.Buffer
+	buf *bytes.Buffer
+	// out receives the output as it is rendered when streaming, flushed
+	// counting the bytes of buf written out so far. No output is written out
+	// while pins is positive.
+	out     io.Writer
+	flushed int
+	pins    int
 
+	cancelCtx       context.Context
+	visited         *int
+	footnotes       *[]string
+	tables          *[]Table
+	stats           *Stats
+	quoteLevel      int
+	isInLink        bool
+	atWordBreak     bool
+	gluesNext       bool
+	spacedText      bool
+	isText          bool
+	rowCells        int
+	depth           int
+	lang            string
 	prefix          string
 	tableCtx        tableTraverseContext
 	options         Options
@@ -125,95 +881,340 @@ type textifyTraverseContext struct {
 	justClosedDiv   bool
 	blockquoteLevel int
 	lineLength      int
+	indent          int
 	isPre           bool
+	noWrap          bool
+	isOrderedList   bool
+	listCounter     int
+	listStep        int
+	listDepth       int
 }
 
 // tableTraverseContext holds table ASCII-form related context.
 type tableTraverseContext struct {
+	caption    string
 	header     []string
 	body       [][]string
-	footer     []string
+	footer     [][]string
 	tmpRow     int
+	headerRow  int
+	rowspans   map[int]int
+	isInHeader bool
 	isInFooter bool
+	// columnAlignment holds the tablewriter alignment of the columns declared
+	// by col and colgroup elements.
+	columnAlignment []int
 }
 
 func (tableCtx *tableTraverseContext) init() {
+	tableCtx.caption = ""
 	tableCtx.body = [][]string{}
 	tableCtx.header = []string{}
-	tableCtx.footer = []string{}
+	tableCtx.footer = [][]string{}
+	tableCtx.isInHeader = false
 	tableCtx.isInFooter = false
 	tableCtx.tmpRow = 0
+	tableCtx.headerRow = -1
+	tableCtx.rowspans = map[int]int{}
+	tableCtx.columnAlignment = nil
 }
 
-func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
-	ctx.justClosedDiv = false
-
-	switch node.DataAtom {
-	case atom.Br:
-		return ctx.emit("\n")
-
-	case atom.H1, atom.H2, atom.H3:
-		subCtx := textifyTraverseContext{}
-		if err := subCtx.traverseChildren(node); err != nil {
-			return err
-		}
+// addColumns declares the alignment of the next span columns from the align
+// attribute of a col or colgroup element, or of its colgroup parent.
+func (tableCtx *tableTraverseContext) addColumns(node *html.Node) {
+	align := getAttrVal(node, "align")
+	if align == "" && node.Parent != nil && node.Parent.DataAtom == atom.Colgroup {
+		align = getAttrVal(node.Parent, "align")
+	}
+	alignment := tablewriter.ALIGN_DEFAULT
+	switch strings.ToLower(strings.TrimSpace(align)) {
+	case "left":
+		alignment = tablewriter.ALIGN_LEFT
+	case "center":
+		alignment = tablewriter.ALIGN_CENTER
+	case "right":
+		alignment = tablewriter.ALIGN_RIGHT
+	}
+	for i := getSpanAttrVal(node, "span"); i > 0; i-- {
+		tableCtx.columnAlignment = append(tableCtx.columnAlignment, alignment)
+	}
+}
 
-		str := subCtx.buf.String()
-		if ctx.options.TextOnly {
-			return ctx.emit(str + ".\n\n")
-		}
-		dividerLen := 0
-		for _, line := range strings.Split(str, "\n") {
-			if lineLen := len([]rune(line)); lineLen-1 > dividerLen {
-				dividerLen = lineLen - 1
+// fillSpannedCells appends empty cells to the current body row for the
+// columns still covered by a rowspan from a previous row. Unless toEnd is set,
+// it stops at the first column not covered.
+func (tableCtx *tableTraverseContext) fillSpannedCells(toEnd bool) {
+	lastCol := -1
+	if toEnd {
+		for col, rows := range tableCtx.rowspans {
+			if rows > 0 && col > lastCol {
+				lastCol = col
 			}
 		}
-		var divider string
-		if node.DataAtom == atom.H1 {
-			divider = strings.Repeat("*", dividerLen)
-		} else {
-			divider = strings.Repeat("-", dividerLen)
+	}
+	row := tableCtx.body[tableCtx.tmpRow]
+	for col := len(row); tableCtx.rowspans[col] > 0 || col <= lastCol; col++ {
+		if tableCtx.rowspans[col] > 0 {
+			tableCtx.rowspans[col]--
 		}
+		row = append(row, "")
+	}
+	tableCtx.body[tableCtx.tmpRow] = row
+}
 
-		if node.DataAtom == atom.H3 {
-			return ctx.emit("\n\n" + str + "\n" + divider + "\n\n")
-		}
-		return ctx.emit("\n\n" + divider + "\n" + str + "\n" + divider + "\n\n")
+// spanRows records that the cells of the columns from col to col+n-1 span
+// the given number of rows, the current one included.
+func (tableCtx *tableTraverseContext) spanRows(col, n, rows int) {
+	if rows < 2 {
+		return
+	}
+	// Cells may be handled without the table, as in fragments.
+	if tableCtx.rowspans == nil {
+		tableCtx.rowspans = map[int]int{}
+	}
+	for i := 0; i < n; i++ {
+		tableCtx.rowspans[col+i] = rows - 1
+	}
+}
 
-	case atom.Blockquote:
-		ctx.blockquoteLevel++
-		if !ctx.options.TextOnly {
-			ctx.prefix = strings.Repeat(">", ctx.blockquoteLevel) + " "
-		}
-		if err := ctx.emit("\n"); err != nil {
-			return err
+// openImplicitRow starts a body row for cells found outside of any row, as
+// in malformed tables built without the parser, which wraps such cells.
+func (tableCtx *tableTraverseContext) openImplicitRow() {
+	if tableCtx.tmpRow == len(tableCtx.body) {
+		tableCtx.body = append(tableCtx.body, []string{})
+	}
+}
+
+// closeImplicitRow ends the body row started by openImplicitRow, if any.
+func (tableCtx *tableTraverseContext) closeImplicitRow() {
+	if tableCtx.tmpRow == len(tableCtx.body) {
+		return
+	}
+	if len(tableCtx.body[tableCtx.tmpRow]) > 0 {
+		tableCtx.fillSpannedCells(true)
+	}
+	tableCtx.tmpRow++
+}
+
+// splitFooter returns the body rows and the footer row of the table. As
+// tablewriter supports a single footer row, footer rows but the last one
+// are rendered at the end of the body.
+func (tableCtx *tableTraverseContext) splitFooter() ([][]string, []string) {
+	if len(tableCtx.footer) == 0 {
+		return tableCtx.body, []string{}
+	}
+	last := len(tableCtx.footer) - 1
+	body := append(append([][]string{}, tableCtx.body...), tableCtx.footer[:last]...)
+	return body, tableCtx.footer[last]
+}
+
+// table returns the collected table data, leaving out the empty body rows
+// left by header and footer rows.
+func (tableCtx *tableTraverseContext) table() Table {
+	body, footer := tableCtx.splitFooter()
+	table := Table{
+		Header: tableCtx.header,
+		Body:   [][]string{},
+		Footer: footer,
+	}
+	for _, row := range body {
+		if len(row) > 0 {
+			table.Body = append(table.Body, row)
 		}
-		if ctx.blockquoteLevel == 1 {
-			if err := ctx.emit("\n"); err != nil {
-				return err
+	}
+	return table
+}
+
+// fitWidth wraps the cell text so that the grid rendered by tablewriter,
+// borders and padding included, doesn't exceed maxWidth. Every column keeps
+// room for its longest word, and the rest of the available width is
+// distributed proportionally to how much each column would take beyond it.
+func (tableCtx *tableTraverseContext) fitWidth(maxWidth int) {
+	rows := tableCtx.rows()
+
+	widths, minWidths := []int{}, []int{}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i == len(widths) {
+				widths = append(widths, 0)
+				minWidths = append(minWidths, 1)
+			}
+			for _, line := range strings.Split(cell, "\n") {
+				if w := tablewriter.DisplayWidth(line); w > widths[i] {
+					widths[i] = w
+				}
+				for _, word := range strings.Fields(line) {
+					if w := tablewriter.DisplayWidth(word); w > minWidths[i] {
+						minWidths[i] = w
+					}
+				}
 			}
 		}
-		if err := ctx.traverseChildren(node); err != nil {
-			return err
-		}
-		ctx.blockquoteLevel--
-		if !ctx.options.TextOnly {
-			ctx.prefix = strings.Repeat(">", ctx.blockquoteLevel)
+	}
+
+	total, minTotal := 0, 0
+	for i := range widths {
+		total += widths[i]
+		minTotal += minWidths[i]
+	}
+	available := maxWidth - 3*len(widths) - 1
+	if total <= available {
+		return
+	}
+	extra := available - minTotal
+	if extra < 0 {
+		extra = 0
+	}
+	for i := range widths {
+		if total == minTotal {
+			// Only unbreakable words, which can't be wrapped any further.
+			widths[i] = minWidths[i]
+			continue
 		}
-		if ctx.blockquoteLevel > 0 {
-			ctx.prefix += " "
+		widths[i] = minWidths[i] + (widths[i]-minWidths[i])*extra/(total-minTotal)
+	}
+
+	for _, row := range rows {
+		for i, cell := range row {
+			row[i] = wrapLines(cell, widths[i])
 		}
-		return ctx.emit("\n\n")
+	}
+}
 
-	case atom.Div:
+// rows returns the header, body and footer rows of the table.
+func (tableCtx *tableTraverseContext) rows() [][]string {
+	rows := make([][]string, 0, len(tableCtx.body)+len(tableCtx.footer)+1)
+	rows = append(rows, tableCtx.header)
+	rows = append(rows, tableCtx.body...)
+	return append(rows, tableCtx.footer...)
+}
+
+// columns returns the number of columns of the table, that of its widest row.
+func (tableCtx *tableTraverseContext) columns() int {
+	columns := 0
+	for _, row := range tableCtx.rows() {
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+	return columns
+}
+
+// padRow returns row completed with empty cells up to the given number of
+// columns.
+func padRow(row []string, columns int) []string {
+	if len(row) >= columns {
+		return row
+	}
+	return append(append(make([]string, 0, columns), row...), make([]string, columns-len(row))...)
+}
+
+// wrapLines wraps each line of text at width, keeping its line breaks.
+func wrapLines(text string, width int) string {
+	lines := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		wrapped, _ := tablewriter.WrapString(line, width)
+		lines = append(lines, wrapped...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// isHeaderRow reports whether the current row may hold header cells: the
+// header is taken from the first row providing any.
+func (tableCtx *tableTraverseContext) isHeaderRow() bool {
+	return tableCtx.headerRow < 0 || tableCtx.headerRow == tableCtx.tmpRow
+}
+
+func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
+	ctx.justClosedDiv = false
+
+	if isHidden(node) || ctx.isSkipped(node) {
+		return nil
+	}
+
+	if !ctx.isAllowed(node.DataAtom) {
+		if ctx.options.SkipDisallowedAtoms {
+			return nil
+		}
+		return ctx.traverseChildren(node)
+	}
+
+	if handler := ctx.options.NodeHandlers[node.DataAtom]; handler != nil {
+		return ctx.handleWithNodeHandler(node, handler)
+	}
+
+	switch node.DataAtom {
+	case atom.Br:
+		return ctx.emit("\n")
+
+	case atom.H1, atom.H2, atom.H3, atom.H4, atom.H5, atom.H6:
+		return ctx.headingHandler(node, headingLevels[node.DataAtom])
+
+	case atom.Hr:
+		if err := ctx.emit("\n\n"); err != nil {
+			return err
+		}
+		if !ctx.options.TextOnly {
+			// Keep the divider within the line width, blockquote prefix included,
+			// as long as it stays visible.
+			dividerLen := ctx.lineWidth() - len([]rune(ctx.prefix))
+			if dividerLen < minDividerLen {
+				dividerLen = minDividerLen
+			}
+			divider := strings.Repeat("-", dividerLen)
+			if err := ctx.emit(divider); err != nil {
+				return err
+			}
+		}
+		return ctx.emit("\n\n")
+
+	case atom.Blockquote:
+		// Keep the state to leave out quotes with nothing rendered, such as
+		// those holding only skipped elements.
+		state := ctx.outputState()
+		ctx.pins++
+		ctx.blockquoteLevel++
+		if !ctx.options.TextOnly {
+			ctx.prefix = ctx.blockquotePrefix()
+		}
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+		if ctx.blockquoteLevel == 1 {
+			if err := ctx.emit("\n"); err != nil {
+				return err
+			}
+		}
+		contentStart := ctx.buf.Len()
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		isBlank := ctx.isBlankSince(contentStart)
+		ctx.pins--
+		ctx.blockquoteLevel--
+		if !ctx.options.TextOnly {
+			ctx.prefix = ctx.blockquotePrefix()
+		}
+		if isBlank {
+			return ctx.dropSince(state)
+		}
+		return ctx.emit("\n\n")
+
+	case atom.Div:
 		if ctx.lineLength > 0 {
 			if err := ctx.emit("\n"); err != nil {
 				return err
 			}
 		}
+		start := ctx.outputLen()
 		if err := ctx.traverseChildren(node); err != nil {
 			return err
 		}
+		// Nothing rendered, such as skipped elements only, adds no blank line.
+		if ctx.outputLen() == start && ctx.lineLength == 0 {
+			ctx.justClosedDiv = true
+			return nil
+		}
 		var err error
 		if !ctx.justClosedDiv {
 			err = ctx.emit("\n")
@@ -223,29 +1224,310 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 
 	case atom.Li:
 		if !ctx.options.TextOnly {
-			if err := ctx.emit("* "); err != nil {
+			marker := "* "
+			if ctx.options.ListBullet != "" {
+				marker = ctx.options.ListBullet + " "
+			}
+			if ctx.isOrderedList {
+				marker = strconv.Itoa(ctx.listCounter) + ". "
+				ctx.listCounter += ctx.listStep
+			}
+			if ctx.listDepth > 1 {
+				marker = strings.Repeat("  ", ctx.listDepth-1) + marker
+			}
+			if err := ctx.emit(marker); err != nil {
 				return err
 			}
+			// Wrapped lines are aligned with the text of the item.
+			indent := ctx.indent
+			ctx.indent = len([]rune(marker))
+			defer func() { ctx.indent = indent }()
 		}
 
 		if err := ctx.traverseChildren(node); err != nil {
 			return err
 		}
 
+		// A nested list may already have terminated the line.
+		if ctx.lineLength == 0 {
+			return nil
+		}
 		return ctx.emit("\n")
 
 	case atom.B, atom.Strong:
-		subCtx := textifyTraverseContext{}
-		subCtx.endsWithSpace = true
-		if err := subCtx.traverseChildren(node); err != nil {
+		str, err := ctx.renderChildren(node)
+		if err != nil {
 			return err
 		}
-		str := subCtx.buf.String()
+		if str == "" {
+			return nil
+		}
+		if ctx.isPre {
+			return ctx.emit(str)
+		}
 		if ctx.options.TextOnly {
-			return ctx.emit(str + ".")
+			// Only end sentences along with blocks.
+			if endsAtBlockBoundary(node) {
+				str += "."
+			}
+			return ctx.emit(str)
 		}
 		return ctx.emit("*" + str + "*")
 
+	case atom.Em, atom.I:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if str == "" {
+			return nil
+		}
+		if ctx.isPre {
+			return ctx.emit(str)
+		}
+		if ctx.options.TextOnly {
+			// Only end sentences along with blocks.
+			if endsAtBlockBoundary(node) {
+				str += "."
+			}
+			return ctx.emit(str)
+		}
+		return ctx.emit("_" + str + "_")
+
+	case atom.Cite, atom.Dfn:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || ctx.isPre || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("_" + str + "_")
+
+	case atom.Del, atom.S, atom.Strike:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || ctx.isPre || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("~~" + str + "~~")
+
+	case atom.Ins:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		if ctx.options.Markdown {
+			return ctx.emit("++" + str + "++")
+		}
+		return ctx.emit("[+" + str + "+]")
+
+	case atom.Mark:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		delimiter := ctx.options.MarkDelimiter
+		if delimiter == "" {
+			delimiter = "=="
+		}
+		return ctx.emit(delimiter + str + delimiter)
+
+	case atom.Sup, atom.Sub:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if str == "" {
+			return nil
+		}
+		marker, runes := "^", superscripts
+		if node.DataAtom == atom.Sub {
+			marker, runes = "_", subscripts
+		}
+		if ctx.options.UnicodeSupSub {
+			if mapped, ok := mapRunes(str, runes); ok {
+				str, marker = mapped, ""
+			}
+		}
+		if ctx.options.TextOnly {
+			marker = ""
+		}
+		if marker != "" && len([]rune(str)) > 1 {
+			str = "(" + str + ")"
+		}
+		if err := ctx.hug(marker + str); err != nil {
+			return err
+		}
+		// Text following without spacing in the document belongs to the
+		// same word, as in H₂O.
+		ctx.endsWithSpace, ctx.gluesNext = true, true
+		return nil
+
+	case atom.Button:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		str = strings.TrimSpace(str)
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("[ " + str + " ]")
+
+	case atom.Input:
+		if !ctx.options.RenderFormControls {
+			return nil
+		}
+		// Checkboxes and radio buttons show their state rather than their
+		// value, and password values aren't to be disclosed.
+		inputType := strings.ToLower(strings.TrimSpace(getAttrVal(node, "type")))
+		switch inputType {
+		case "hidden", "password", "file":
+			return nil
+		case "checkbox", "radio":
+			if ctx.options.TextOnly {
+				return nil
+			}
+			state := " "
+			if hasAttr(node, "checked") {
+				state = "x"
+			}
+			if inputType == "radio" {
+				return ctx.emit("(" + state + ")")
+			}
+			return ctx.emit("[" + state + "]")
+		}
+		str := strings.TrimSpace(getAttrVal(node, "value"))
+		if str == "" {
+			str = strings.TrimSpace(getAttrVal(node, "placeholder"))
+		}
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("[ " + str + " ]")
+
+	case atom.Iframe:
+		src := strings.TrimSpace(getAttrVal(node, "src"))
+		if !ctx.options.RenderIframes || src == "" {
+			return ctx.traverseChildren(node)
+		}
+		if title := strings.TrimSpace(getAttrVal(node, "title")); title != "" {
+			src = title + " ( " + src + " )"
+		}
+		if ctx.lineLength > 0 {
+			if err := ctx.emit("\n"); err != nil {
+				return err
+			}
+		}
+		return ctx.emit("[embedded content: " + src + "]\n")
+
+	case atom.Video, atom.Audio:
+		if !ctx.options.RenderMedia {
+			return ctx.traverseChildren(node)
+		}
+		return ctx.mediaHandler(node)
+
+	case atom.Select:
+		if !ctx.options.RenderFormControls {
+			return ctx.traverseChildren(node)
+		}
+		return ctx.selectHandler(node)
+
+	case atom.Progress, atom.Meter:
+		if !ctx.options.RenderFormControls {
+			return ctx.traverseChildren(node)
+		}
+		return ctx.gaugeHandler(node)
+
+	case atom.Span:
+		wrapper := ctx.classStyleWrapper(node)
+		if wrapper == "" || ctx.options.TextOnly || ctx.isPre {
+			return ctx.traverseChildren(node)
+		}
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if str == "" {
+			return nil
+		}
+		return ctx.emit(wrapper + str + wrapper)
+
+	case atom.Abbr:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		title := strings.TrimSpace(getAttrVal(node, "title"))
+		if !ctx.options.ExpandAbbreviations || title == "" || title == str {
+			return ctx.emit(str)
+		}
+		return ctx.emit(str + " (" + title + ")")
+
+	case atom.Time:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		datetime := strings.TrimSpace(getAttrVal(node, "datetime"))
+		if !ctx.options.ShowTimeDatetime || datetime == "" || datetime == str {
+			return ctx.emit(str)
+		}
+		return ctx.emit(str + " (" + datetime + ")")
+
+	case atom.Q:
+		subCtx := ctx.subContext()
+		defer subCtx.release()
+		subCtx.quoteLevel = ctx.quoteLevel + 1
+		if err := subCtx.traverseChildren(node); err != nil {
+			return err
+		}
+		// Nested quotations alternate between double and single quotes.
+		quotes := [2]string{`"`, `"`}
+		if ctx.quoteLevel%2 == 1 {
+			quotes = [2]string{"'", "'"}
+		}
+		if ctx.options.UnicodeQuotes {
+			quotes = [2]string{"“", "”"}
+			if ctx.quoteLevel%2 == 1 {
+				quotes = [2]string{"‘", "’"}
+			}
+		}
+		return ctx.emit(quotes[0] + subCtx.buf.String() + quotes[1])
+
+	case atom.Code, atom.Samp, atom.Var:
+		// Code within preformatted blocks is already rendered verbatim.
+		if ctx.isPre {
+			return ctx.traverseChildren(node)
+		}
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("`" + str + "`")
+
+	case atom.Kbd:
+		str, err := ctx.renderChildren(node)
+		if err != nil {
+			return err
+		}
+		if ctx.options.TextOnly || str == "" {
+			return ctx.emit(str)
+		}
+		return ctx.emit("[" + str + "]")
+
 	case atom.A:
 		linkText := ""
 		// For simple link element content with single text node only, peek at the link text.
@@ -253,69 +1535,871 @@ func (ctx *textifyTraverseContext) handleElement(node *html.Node) error {
 			linkText = node.FirstChild.Data
 		}
 
-		// If image is the only child, take its alt text as the link text.
-		if img := node.FirstChild; img != nil && node.LastChild == img && img.DataAtom == atom.Img {
-			if altText := getAttrVal(img, "alt"); altText != "" {
-				if err := ctx.emit(altText); err != nil {
-					return err
-				}
-			}
-		} else if err := ctx.traverseChildren(node); err != nil {
+		// Bracketed link text is rendered apart, to be wrapped once complete.
+		var (
+			textCtx = ctx
+			subCtx  textifyTraverseContext
+		)
+		if ctx.options.BracketLinkText {
+			subCtx = ctx.subContext()
+			defer subCtx.release()
+			textCtx = &subCtx
+		}
+		isInLink := textCtx.isInLink
+		textCtx.isInLink = true
+		err := textCtx.traverseChildren(node)
+		textCtx.isInLink = isInLink
+		if err != nil {
 			return err
 		}
 
 		hrefLink := ""
 		if attrVal := getAttrVal(node, "href"); attrVal != "" {
+			if ctx.stats != nil {
+				ctx.stats.LinkCount++
+			}
 			attrVal = ctx.normalizeHrefLink(attrVal)
 			// Don't print link href if it matches link element content or if the link is empty.
-			if (attrVal != "" && linkText != attrVal) && !ctx.options.OmitLinks && !ctx.options.TextOnly {
-				hrefLink = "( " + attrVal + " )"
+			if (attrVal != "" && (linkText != attrVal || ctx.options.BracketLinkText)) && !ctx.options.OmitLinks && !ctx.options.TextOnly && !ctx.isOmittedLink(attrVal) {
+				if ctx.options.LinkFootnotes {
+					hrefLink = "[" + strconv.Itoa(ctx.addFootnote(attrVal)) + "]"
+				} else if title := strings.TrimSpace(getAttrVal(node, "title")); ctx.options.PreferLinkTitle && title != "" {
+					hrefLink = "( " + title + " )"
+				} else {
+					hrefLink = "( " + shortenLink(attrVal, ctx.options.MaxLinkLength) + " )"
+				}
+				if rel := strings.Join(strings.Fields(getAttrVal(node, "rel")), " "); ctx.options.ShowLinkRel && rel != "" {
+					hrefLink += " [" + rel + "]"
+				}
+			}
+		}
+
+		if !ctx.options.BracketLinkText {
+			return ctx.annotate(hrefLink)
+		}
+		str := subCtx.buf.String()
+		if hrefLink == "" {
+			return ctx.emit(str)
+		}
+		if err := ctx.emit("[" + str + "]"); err != nil {
+			return err
+		}
+		return ctx.hug(hrefLink)
+
+	case atom.Img:
+		altText := getAttrVal(node, "alt")
+		if altText == "" {
+			altText = getAttrVal(node, "title")
+		}
+		if err := ctx.emit(altText); err != nil {
+			return err
+		}
+		if !ctx.options.IncludeImageSrc || ctx.options.TextOnly {
+			return nil
+		}
+		if src := strings.TrimSpace(getAttrVal(node, "src")); src != "" {
+			width := strings.TrimSpace(getAttrVal(node, "width"))
+			height := strings.TrimSpace(getAttrVal(node, "height"))
+			if width != "" && height != "" {
+				src += " " + width + "x" + height
+			}
+			return ctx.annotate("( " + src + " )")
+		}
+		return nil
+
+	case atom.Ul:
+		isOrderedList := ctx.isOrderedList
+		ctx.isOrderedList = false
+		err := ctx.listHandler(node)
+		ctx.isOrderedList = isOrderedList
+		return err
+
+	case atom.Ol:
+		start, step := 1, 1
+		if hasAttr(node, "reversed") {
+			// Reversed lists count down from their number of items.
+			start, step = 0, -1
+			for c := node.FirstChild; c != nil; c = c.NextSibling {
+				if c.DataAtom == atom.Li {
+					start++
+				}
+			}
+		}
+		if n, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, "start"))); err == nil {
+			start = n
+		}
+		// Save the enclosing list state so that nested lists don't disturb it.
+		isOrderedList, listCounter, listStep := ctx.isOrderedList, ctx.listCounter, ctx.listStep
+		ctx.isOrderedList, ctx.listCounter, ctx.listStep = true, start, step
+		err := ctx.listHandler(node)
+		ctx.isOrderedList, ctx.listCounter, ctx.listStep = isOrderedList, listCounter, listStep
+		return err
+
+	case atom.P, atom.Dl:
+		return ctx.paragraphHandler(node)
+
+	case atom.Nav, atom.Aside, atom.Article, atom.Section:
+		if node.DataAtom == atom.Nav && ctx.options.SkipNav {
+			return nil
+		}
+		return ctx.paragraphHandler(node)
+
+	case atom.Address:
+		if !ctx.options.Markdown || ctx.options.TextOnly {
+			return ctx.paragraphHandler(node)
+		}
+		subCtx := ctx.subContext()
+		defer subCtx.release()
+		if err := subCtx.traverseChildren(node); err != nil {
+			return err
+		}
+		str := subCtx.text()
+		if str == "" {
+			return nil
+		}
+		return ctx.emit("\n\n_" + str + "_\n\n")
+
+	case atom.Figure:
+		if err := ctx.emit("\n\n"); err != nil {
+			return err
+		}
+		// Captions come last, whatever their position in the figure.
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom != atom.Figcaption {
+				if err := ctx.traverse(c); err != nil {
+					return err
+				}
 			}
 		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Figcaption {
+				if err := ctx.traverse(c); err != nil {
+					return err
+				}
+			}
+		}
+		return ctx.emit("\n\n")
+
+	case atom.Figcaption:
+		if ctx.lineLength > 0 {
+			if err := ctx.emit("\n"); err != nil {
+				return err
+			}
+		}
+		if !ctx.options.TextOnly {
+			prefix := ctx.options.FigurePrefix
+			if prefix == "" {
+				prefix = "Figure: "
+			}
+			if err := ctx.emit(prefix); err != nil {
+				return err
+			}
+		}
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		return ctx.emit("\n")
+
+	case atom.Details:
+		return ctx.detailsHandler(node)
+
+	case atom.Summary:
+		if ctx.lineLength > 0 {
+			if err := ctx.emit("\n"); err != nil {
+				return err
+			}
+		}
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		return ctx.emit("\n")
+
+	case atom.Dt, atom.Dd:
+		if ctx.lineLength > 0 {
+			if err := ctx.emit("\n"); err != nil {
+				return err
+			}
+		}
+		// Definitions are indented beneath their term.
+		if node.DataAtom == atom.Dd && !ctx.options.TextOnly {
+			if err := ctx.emit("  "); err != nil {
+				return err
+			}
+			indent := ctx.indent
+			ctx.indent = 2
+			defer func() { ctx.indent = indent }()
+		}
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		return ctx.emit("\n")
+
+	case atom.Table, atom.Caption, atom.Colgroup, atom.Col, atom.Thead, atom.Tfoot, atom.Th, atom.Tr, atom.Td:
+		if node.DataAtom == atom.Table && ctx.stats != nil {
+			ctx.stats.TableCount++
+		}
+		if ctx.options.PrettyTables {
+			return ctx.handleTableElement(node)
+		}
+		switch node.DataAtom {
+		case atom.Table, atom.Caption:
+			return ctx.paragraphHandler(node)
+		case atom.Tr:
+			return ctx.plainTableRowHandler(node)
+		case atom.Th, atom.Td:
+			return ctx.plainTableCellHandler(node)
+		}
+		return ctx.traverseChildren(node)
+
+	case atom.Pre:
+		if code := getCodeChild(node); code != nil && ctx.options.Markdown && !ctx.options.TextOnly {
+			return ctx.fencedCodeHandler(node, code)
+		}
+		ctx.isPre = true
+		err := ctx.traverseChildren(node)
+		ctx.isPre = false
+		return err
+
+	case atom.Wbr:
+		// Glue the following text to the preceding one, but let long lines
+		// break in between.
+		ctx.endsWithSpace = true
+		ctx.atWordBreak = true
+		return nil
+
+	case atom.Style:
+		// Ignore the subtree.
+		return nil
+	case atom.Head:
+		// Ignore the subtree, except for the title and description when included.
+		return ctx.headHandler(node)
+	case atom.Script:
+		// Ignore the subtree.
+		return nil
+	case atom.Noscript:
+		if ctx.options.SkipNoscript {
+			return nil
+		}
+		return ctx.traverseChildren(node)
+
+	default:
+		return ctx.traverseChildren(node)
+	}
+}
+
+// handleWithNodeHandler renders the node children, then emits what the
+// handler makes of them.
+func (ctx *textifyTraverseContext) handleWithNodeHandler(node *html.Node, handler NodeHandler) error {
+	str, err := ctx.renderChildren(node)
+	if err != nil {
+		return err
+	}
+	str, err = handler(node, str)
+	if err != nil {
+		return err
+	}
+	return ctx.emit(str)
+}
+
+// subContext returns a context rendering into its own buffer with the same
+// options, used to post-process the text of an element before emitting it.
+// Lines are only broken once the text is emitted, knowing where it starts.
+func (ctx *textifyTraverseContext) subContext() textifyTraverseContext {
+	return textifyTraverseContext{
+		buf:           bufferPool.Get().(*bytes.Buffer),
+		noWrap:        true,
+		options:       ctx.options,
+		cancelCtx:     ctx.cancelCtx,
+		visited:       ctx.visited,
+		footnotes:     ctx.footnotes,
+		tables:        ctx.tables,
+		stats:         ctx.stats,
+		quoteLevel:    ctx.quoteLevel,
+		isInLink:      ctx.isInLink,
+		isPre:         ctx.isPre,
+		depth:         ctx.depth,
+		lang:          ctx.lang,
+		endsWithSpace: true,
+	}
+}
+
+// renderChildren returns the text rendered by the children of node in a
+// sub-context, as is.
+func (ctx *textifyTraverseContext) renderChildren(node *html.Node) (string, error) {
+	subCtx := ctx.subContext()
+	defer subCtx.release()
+	if err := subCtx.traverseChildren(node); err != nil {
+		return "", err
+	}
+	return subCtx.buf.String(), nil
+}
+
+// maxPooledBufferSize is the capacity above which buffers are left to the
+// garbage collector rather than kept for reuse.
+const maxPooledBufferSize = 1 << 16
+
+// bufferPool recycles the buffers contexts render into, a sub-context being
+// created for most inline elements and table cells.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return &bytes.Buffer{}
+	},
+}
+
+// release puts the buffer of the context back in bufferPool once its text is
+// retrieved. The context is not used afterwards.
+func (ctx *textifyTraverseContext) release() {
+	if ctx.buf.Cap() <= maxPooledBufferSize {
+		ctx.buf.Reset()
+		bufferPool.Put(ctx.buf)
+	}
+	ctx.buf = nil
+}
+
+// blockContext returns a sub-context rendering a whole block, starting on a
+// line of its own and thus breaking its long lines itself.
+func (ctx *textifyTraverseContext) blockContext() textifyTraverseContext {
+	subCtx := ctx.subContext()
+	subCtx.noWrap = ctx.noWrap
+	return subCtx
+}
+
+// mediaHandler renders a video or audio element as a placeholder line
+// referencing its source and caption tracks, leaving out fallback content.
+func (ctx *textifyTraverseContext) mediaHandler(node *html.Node) error {
+	var (
+		src    = strings.TrimSpace(getAttrVal(node, "src"))
+		tracks []string
+	)
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch c.DataAtom {
+		case atom.Source:
+			if src == "" {
+				src = strings.TrimSpace(getAttrVal(c, "src"))
+			}
+		case atom.Track:
+			if trackSrc := strings.TrimSpace(getAttrVal(c, "src")); trackSrc != "" {
+				tracks = append(tracks, trackSrc)
+			}
+		}
+	}
+	placeholder := "[" + node.Data
+	if src != "" {
+		placeholder += ": " + src
+	}
+	if len(tracks) > 0 {
+		placeholder += ", captions: " + strings.Join(tracks, ", ")
+	}
+	placeholder += "]"
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+	return ctx.emit(placeholder + "\n")
+}
+
+// gaugeHandler renders progress and meter elements as the percentage of their
+// value within their range, falling back to their content without a valid
+// value.
+func (ctx *textifyTraverseContext) gaugeHandler(node *html.Node) error {
+	parseAttr := func(key string, defaultVal float64) float64 {
+		val, err := strconv.ParseFloat(strings.TrimSpace(getAttrVal(node, key)), 64)
+		if err != nil {
+			return defaultVal
+		}
+		return val
+	}
+	value := parseAttr("value", math.NaN())
+	minVal := 0.0
+	if node.DataAtom == atom.Meter {
+		minVal = parseAttr("min", 0)
+	}
+	maxVal := parseAttr("max", 1)
+	if math.IsNaN(value) || maxVal <= minVal {
+		return ctx.traverseChildren(node)
+	}
+
+	value = math.Max(minVal, math.Min(value, maxVal))
+	percent := strconv.Itoa(int(math.Round((value-minVal)/(maxVal-minVal)*100))) + "%"
+	if ctx.options.TextOnly {
+		return ctx.emit(percent)
+	}
+	return ctx.emit("[" + node.Data + ": " + percent + "]")
+}
+
+// selectHandler renders the selected option of a dropdown, the first one when
+// none is, or all of them as a list with ListSelectOptions.
+func (ctx *textifyTraverseContext) selectHandler(node *html.Node) error {
+	var (
+		options  []string
+		selected = -1
+	)
+	var collect func(*html.Node) error
+	collect = func(n *html.Node) error {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if c.DataAtom == atom.Optgroup {
+				if err := collect(c); err != nil {
+					return err
+				}
+				continue
+			}
+			if c.DataAtom != atom.Option {
+				continue
+			}
+			str := strings.TrimSpace(getAttrVal(c, "label"))
+			if str == "" {
+				subCtx := ctx.subContext()
+				if err := subCtx.traverseChildren(c); err != nil {
+					return err
+				}
+				str = subCtx.text()
+				subCtx.release()
+			}
+			if selected < 0 && hasAttr(c, "selected") {
+				selected = len(options)
+			}
+			options = append(options, str)
+		}
+		return nil
+	}
+	if err := collect(node); err != nil {
+		return err
+	}
+	if len(options) == 0 {
+		return nil
+	}
+
+	if ctx.options.ListSelectOptions {
+		bullet := "* "
+		if ctx.options.ListBullet != "" {
+			bullet = ctx.options.ListBullet + " "
+		}
+		if ctx.options.TextOnly {
+			bullet = ""
+		}
+		if err := ctx.emit("\n\n"); err != nil {
+			return err
+		}
+		for _, option := range options {
+			if err := ctx.emit(bullet + option + "\n"); err != nil {
+				return err
+			}
+		}
+		return ctx.emit("\n")
+	}
+
+	if selected < 0 {
+		selected = 0
+	}
+	if ctx.options.TextOnly || options[selected] == "" {
+		return ctx.emit(options[selected])
+	}
+	return ctx.emit("[ " + options[selected] + " ]")
+}
+
+// detailsHandler renders the summary of a disclosure widget on its own line,
+// followed by the rest of its content indented below. In Markdown, the summary
+// is a bold line and the content a blockquote.
+func (ctx *textifyTraverseContext) detailsHandler(node *html.Node) error {
+	var (
+		summaryCtx = ctx.subContext()
+		bodyCtx    = ctx.subContext()
+	)
+	defer summaryCtx.release()
+	defer bodyCtx.release()
+	// The summary comes first, whatever its position in the widget.
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		subCtx := &bodyCtx
+		if c.DataAtom == atom.Summary {
+			subCtx = &summaryCtx
+		}
+		if err := subCtx.traverse(c); err != nil {
+			return err
+		}
+	}
+	summary, body := summaryCtx.text(), bodyCtx.text()
+	if summary == "" && body == "" {
+		return nil
+	}
+	if err := ctx.emit("\n\n"); err != nil {
+		return err
+	}
+	markdown := ctx.options.Markdown && !ctx.options.TextOnly
+	if summary != "" {
+		if markdown {
+			summary = "**" + summary + "**"
+		} else if !ctx.options.TextOnly {
+			summary = "\u25b8 " + summary
+		}
+		if err := ctx.emit(summary + "\n"); err != nil {
+			return err
+		}
+	}
+	if body != "" {
+		if markdown {
+			lines := strings.Split(body, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight("> "+line, " ")
+			}
+			body = strings.Join(lines, "\n")
+		} else if !ctx.options.TextOnly {
+			lines := strings.Split(body, "\n")
+			for i, line := range lines {
+				if line != "" {
+					lines[i] = "  " + line
+				}
+			}
+			body = strings.Join(lines, "\n")
+		}
+		if err := ctx.emit(body); err != nil {
+			return err
+		}
+	}
+	return ctx.emit("\n\n")
+}
+
+// text returns the rendered text with newline runs squeezed and surrounding
+// whitespace trimmed.
+func (ctx *textifyTraverseContext) text() string {
+	text := squeezeNewlines(ctx.buf.String(), ctx.maxNewlines())
+	if ctx.flushed > 0 {
+		// The beginning of the output is already written out.
+		return strings.TrimRightFunc(text, unicode.IsSpace)
+	}
+	return ctx.trim(text)
+}
+
+// trim removes the leading and trailing whitespace of the output.
+func (ctx *textifyTraverseContext) trim(text string) string {
+	if ctx.options.PreserveWhitespace {
+		return trimBlankLines(text)
+	}
+	return strings.TrimSpace(text)
+}
+
+// maxNewlines returns the number of consecutive newlines longer runs are
+// squeezed to.
+func (ctx *textifyTraverseContext) maxNewlines() int {
+	if ctx.options.MaxConsecutiveNewlines > 0 {
+		return ctx.options.MaxConsecutiveNewlines
+	}
+	return 2
+}
+
+// streamThreshold is the amount of output buffered before it gets written
+// out when streaming.
+const streamThreshold = 1 << 12
+
+// flush writes out the output rendered so far to ctx.out once enough of it is
+// buffered. Trailing whitespace is held back, as it may still be squeezed or
+// trimmed.
+func (ctx *textifyTraverseContext) flush() error {
+	if ctx.pins > 0 || ctx.buf.Len() < streamThreshold {
+		return nil
+	}
+	end := len(bytes.TrimRightFunc(ctx.buf.Bytes(), unicode.IsSpace))
+	if end == 0 {
+		return nil
+	}
+	text := squeezeNewlines(string(ctx.buf.Bytes()[:end]), ctx.maxNewlines())
+	if ctx.flushed == 0 {
+		text = ctx.trim(text)
+	}
+	if _, err := io.WriteString(ctx.out, ctx.withLineEnding(text)); err != nil {
+		return err
+	}
+	ctx.buf.Next(end)
+	ctx.flushed += end
+	return nil
+}
+
+// outputLen returns the length of the output rendered so far, including the
+// part written out.
+func (ctx *textifyTraverseContext) outputLen() int {
+	return ctx.flushed + ctx.buf.Len()
+}
+
+// trimBlankLines removes leading and trailing blank lines as well as trailing
+// spaces, preserving the indentation of the first line.
+func trimBlankLines(text string) string {
+	text = strings.TrimRightFunc(text, unicode.IsSpace)
+	for {
+		i := strings.IndexByte(text, '\n')
+		if i < 0 || strings.TrimSpace(text[:i]) != "" {
+			return text
+		}
+		text = text[i+1:]
+	}
+}
+
+// squeezeNewlines shortens runs of more than maxNewlines newlines to
+// maxNewlines.
+func squeezeNewlines(text string, maxNewlines int) string {
+	var (
+		buf      strings.Builder
+		newlines int
+	)
+	buf.Grow(len(text))
+	for _, r := range text {
+		if r == '\n' {
+			newlines++
+			if newlines > maxNewlines {
+				continue
+			}
+		} else {
+			newlines = 0
+		}
+		buf.WriteRune(r)
+	}
+	return buf.String()
+}
+
+// addFootnote records the link as a footnote unless already known, and
+// returns its number.
+func (ctx *textifyTraverseContext) addFootnote(link string) int {
+	for i, footnote := range *ctx.footnotes {
+		if footnote == link {
+			return i + 1
+		}
+	}
+	*ctx.footnotes = append(*ctx.footnotes, link)
+	return len(*ctx.footnotes)
+}
+
+// headHandler renders the title of the document as a heading and its
+// description as a paragraph, when included. It also picks up the base URL of
+// the document.
+func (ctx *textifyTraverseContext) headHandler(node *html.Node) error {
+	title, description := ctx.headInfo(node)
+	if ctx.options.IncludeTitle && title != nil {
+		if err := ctx.headingHandler(title, 1); err != nil {
+			return err
+		}
+	}
+	if ctx.options.IncludeMetaDescription && description != "" {
+		return ctx.emit("\n\n" + description + "\n\n")
+	}
+	return nil
+}
+
+// headInfo returns the title element and the description of the document
+// from its head, and picks up its base URL.
+func (ctx *textifyTraverseContext) headInfo(node *html.Node) (title *html.Node, description string) {
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.DataAtom == atom.Base:
+			// The base URL applies to the links of the whole document.
+			if href := strings.TrimSpace(getAttrVal(c, "href")); href != "" {
+				if ctx.options.BaseURL != "" {
+					href = resolveLink(ctx.options.BaseURL, href)
+				}
+				ctx.options.BaseURL = href
+			}
+		case c.DataAtom == atom.Title && title == nil:
+			if c.FirstChild != nil && strings.TrimSpace(c.FirstChild.Data) != "" {
+				title = c
+			}
+		case c.DataAtom == atom.Meta && description == "":
+			if strings.EqualFold(getAttrVal(c, "name"), "description") {
+				description = strings.TrimSpace(spacingRe.ReplaceAllString(getAttrVal(c, "content"), " "))
+			}
+		}
+	}
+	return title, description
+}
+
+// headingHandler renders the node as a heading of the level, from 1 to 6.
+func (ctx *textifyTraverseContext) headingHandler(node *html.Node, level int) error {
+	subCtx := ctx.blockContext()
+	defer subCtx.release()
+	if err := subCtx.traverseChildren(node); err != nil {
+		return err
+	}
+
+	str := subCtx.buf.String()
+	if ctx.options.TextOnly {
+		if ctx.options.UppercaseHeadings {
+			str = ctx.toUpper(str)
+		}
+		return ctx.emit(str + ".\n\n")
+	}
+	dividerLen := 0
+	for _, line := range strings.Split(str, "\n") {
+		if lineLen := len([]rune(line)); lineLen > dividerLen {
+			dividerLen = lineLen
+		}
+	}
+	dividerChars := ctx.options.HeadingUnderlines[level-1]
+	if dividerChars == "" {
+		dividerChars = headingDividers[level-1]
+	}
+	divider := string([]rune(strings.Repeat(dividerChars, dividerLen))[:dividerLen])
+
+	// Only the two top levels are overlined.
+	if level > 2 {
+		return ctx.emitUnwrapped("\n\n" + str + "\n" + divider + "\n\n")
+	}
+	return ctx.emitUnwrapped("\n\n" + divider + "\n" + str + "\n" + divider + "\n\n")
+}
+
+// paragraphHandler renders node children surrounded by double newlines.
+func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
+	if ctx.options.DropEmptyBlocks {
+		ctx.pins++
+		defer func() { ctx.pins-- }()
+	}
+	state := ctx.outputState()
+	if err := ctx.emit("\n\n"); err != nil {
+		return err
+	}
+	contentStart := ctx.buf.Len()
+	if err := ctx.traverseChildren(node); err != nil {
+		return err
+	}
+	if ctx.options.DropEmptyBlocks && ctx.isBlankSince(contentStart) {
+		return ctx.dropSince(state)
+	}
+	return ctx.emit("\n\n")
+}
 
-		return ctx.emit(hrefLink)
+// outputState is a position in the rendered output.
+type outputState struct {
+	length        int
+	lineLength    int
+	endsWithSpace bool
+}
 
-	case atom.P, atom.Ul:
-		return ctx.paragraphHandler(node)
+func (ctx *textifyTraverseContext) outputState() outputState {
+	return outputState{
+		length:        ctx.buf.Len(),
+		lineLength:    ctx.lineLength,
+		endsWithSpace: ctx.endsWithSpace,
+	}
+}
 
-	case atom.Table, atom.Tfoot, atom.Th, atom.Tr, atom.Td:
-		if ctx.options.PrettyTables {
-			return ctx.handleTableElement(node)
-		} else if node.DataAtom == atom.Table {
-			return ctx.paragraphHandler(node)
-		}
-		return ctx.traverseChildren(node)
+// isBlankSince reports whether the output rendered since start holds nothing
+// but whitespace and line prefixes.
+func (ctx *textifyTraverseContext) isBlankSince(start int) bool {
+	content := string(ctx.buf.Bytes()[start:])
+	if ctx.prefix != "" {
+		content = strings.ReplaceAll(content, "\n"+ctx.prefix, "\n")
+	}
+	return strings.TrimSpace(content) == ""
+}
 
-	case atom.Pre:
-		ctx.isPre = true
-		err := ctx.traverseChildren(node)
-		ctx.isPre = false
+// dropSince drops the output rendered since state, leaving a line break in
+// place of the dropped block within a line.
+func (ctx *textifyTraverseContext) dropSince(state outputState) error {
+	ctx.buf.Truncate(state.length)
+	ctx.lineLength = state.lineLength
+	ctx.endsWithSpace = state.endsWithSpace
+	if ctx.lineLength > 0 {
+		return ctx.emit("\n")
+	}
+	return nil
+}
+
+// plainTableRowHandler renders a table row on its own line when PrettyTables
+// is off.
+func (ctx *textifyTraverseContext) plainTableRowHandler(node *html.Node) error {
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+	// Cells found outside of rows after this one start a line of their own.
+	ctx.rowCells = 0
+	err := ctx.traverseChildren(node)
+	ctx.rowCells = 0
+	if err != nil {
 		return err
+	}
+	return ctx.emit("\n")
+}
 
-	case atom.Style:
-		// Ignore the subtree.
-		return nil
-	case atom.Head:
-		// Ignore the subtree.
-		return nil
-	case atom.Script:
-		// Ignore the subtree.
-		return nil
+// plainTableCellHandler renders the text of a table cell on a single line,
+// separated from the previous cell of the row, when PrettyTables is off.
+func (ctx *textifyTraverseContext) plainTableCellHandler(node *html.Node) error {
+	subCtx := ctx.subContext()
+	defer subCtx.release()
+	if err := subCtx.traverseChildren(node); err != nil {
+		return err
+	}
+	str := spacingRe.ReplaceAllString(subCtx.text(), " ")
+	ctx.rowCells++
+	if ctx.rowCells == 1 {
+		return ctx.emit(str)
+	}
+	separator := ctx.options.PlainTableSeparator
+	if separator == "" {
+		separator = "\t"
+	}
+	return ctx.hug(separator + str)
+}
 
-	default:
-		return ctx.traverseChildren(node)
+// blockquotePrefix returns the prefix of lines at the current blockquote
+// level: the marker repeated once per level and followed by a space.
+func (ctx *textifyTraverseContext) blockquotePrefix() string {
+	if ctx.blockquoteLevel == 0 {
+		return ""
+	}
+	marker := ctx.options.BlockquotePrefix
+	if marker == "" {
+		marker = ">"
+	}
+	prefix := strings.Repeat(marker, ctx.blockquoteLevel)
+	if !strings.HasSuffix(marker, " ") {
+		prefix += " "
 	}
+	return prefix
 }
 
-// paragraphHandler renders node children surrounded by double newlines.
-func (ctx *textifyTraverseContext) paragraphHandler(node *html.Node) error {
-	if err := ctx.emit("\n\n"); err != nil {
+// fencedCodeHandler renders a <pre><code> block verbatim between Markdown code
+// fences, tagged with the language found in a "language-*" class.
+func (ctx *textifyTraverseContext) fencedCodeHandler(node, code *html.Node) error {
+	language := getClassLanguage(code)
+	if language == "" {
+		language = getClassLanguage(node)
+	}
+	if err := ctx.emit("\n\n```" + language + "\n"); err != nil {
 		return err
 	}
-	if err := ctx.traverseChildren(node); err != nil {
+	ctx.isPre = true
+	err := ctx.traverseChildren(code)
+	ctx.isPre = false
+	if err != nil {
 		return err
 	}
-	return ctx.emit("\n\n")
+	if ctx.lineLength > 0 {
+		if err := ctx.emit("\n"); err != nil {
+			return err
+		}
+	}
+	return ctx.emit("```\n\n")
+}
+
+// listHandler renders list items one level deeper than the enclosing list.
+// Top-level lists are separated like paragraphs, nested ones start on the
+// line following their parent item.
+func (ctx *textifyTraverseContext) listHandler(node *html.Node) error {
+	var err error
+	ctx.listDepth++
+	if ctx.listDepth == 1 {
+		err = ctx.paragraphHandler(node)
+	} else {
+		if ctx.lineLength > 0 {
+			err = ctx.emit("\n")
+		}
+		if err == nil {
+			err = ctx.traverseChildren(node)
+		}
+	}
+	ctx.listDepth--
+	return err
 }
 
 // handleTableElement is only to be invoked when options.PrettyTables is active.
@@ -333,15 +2417,30 @@ func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
 		// Re-intialize all table context.
 		ctx.tableCtx.init()
 
+		// Reserve the table slot first to collect nested tables in document order.
+		tableIndex := 0
+		if ctx.tables != nil {
+			tableIndex = len(*ctx.tables)
+			*ctx.tables = append(*ctx.tables, Table{})
+		}
+
 		// Browse children, enriching context with table data.
 		if err := ctx.traverseChildren(node); err != nil {
 			return err
 		}
+		ctx.tableCtx.closeImplicitRow()
+
+		if ctx.tables != nil {
+			(*ctx.tables)[tableIndex] = ctx.tableCtx.table()
+		}
 
 		buf := &bytes.Buffer{}
 		table := tablewriter.NewWriter(buf)
 		if ctx.options.PrettyTablesOptions != nil {
 			options := ctx.options.PrettyTablesOptions
+			if options.MaxTableWidth > 0 {
+				ctx.tableCtx.fitWidth(options.MaxTableWidth)
+			}
 			table.SetAutoFormatHeaders(options.AutoFormatHeader)
 			table.SetAutoWrapText(options.AutoWrapText)
 			table.SetReflowDuringAutoWrap(options.ReflowDuringAutoWrap)
@@ -358,19 +2457,82 @@ func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
 			table.SetRowLine(options.RowLine)
 			table.SetAutoMergeCells(options.AutoMergeCells)
 			table.SetBorders(options.Borders)
+			if options.PreserveCellNewlines {
+				// Wrap lines apart, as tablewriter would either join them or
+				// separate them with blank lines.
+				if options.AutoWrapText {
+					for _, row := range ctx.tableCtx.rows() {
+						for i, cell := range row {
+							row[i] = wrapLines(cell, options.ColWidth)
+						}
+					}
+				}
+				table.SetAutoWrapText(false)
+			}
+		}
+		// Explicit column alignment prevails over the one of the document.
+		if alignment := ctx.tableCtx.columnAlignment; len(alignment) > 0 && (ctx.options.PrettyTablesOptions == nil || len(ctx.options.PrettyTablesOptions.ColumnAlignment) == 0) {
+			table.SetColumnAlignment(alignment)
+		}
+		body, footer := ctx.tableCtx.splitFooter()
+		// Short rows are padded, as tablewriter expects rows as wide as the
+		// table and panics on a short footer.
+		columns := ctx.tableCtx.columns()
+		for i, row := range body {
+			if len(row) > 0 {
+				body[i] = padRow(row, columns)
+			}
+		}
+		if len(footer) > 0 {
+			footer = padRow(footer, columns)
 		}
 		table.SetHeader(ctx.tableCtx.header)
-		table.SetFooter(ctx.tableCtx.footer)
-		table.AppendBulk(ctx.tableCtx.body)
+		table.SetFooter(footer)
+		table.AppendBulk(body)
 
 		// Render the table using ASCII.
 		table.Render()
-		if err := ctx.emit(buf.String()); err != nil {
+		if ctx.tableCtx.caption != "" {
+			if err := ctx.emit(ctx.tableCtx.caption + "\n"); err != nil {
+				return err
+			}
+		}
+		if err := ctx.emitUnwrapped(buf.String()); err != nil {
 			return err
 		}
 
 		return ctx.emit("\n\n")
 
+	case atom.Colgroup:
+		hasCols := false
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			if c.DataAtom == atom.Col {
+				ctx.tableCtx.addColumns(c)
+				hasCols = true
+			}
+		}
+		if !hasCols {
+			ctx.tableCtx.addColumns(node)
+		}
+
+	case atom.Col:
+		ctx.tableCtx.addColumns(node)
+
+	case atom.Caption:
+		res, err := ctx.renderEachChild(node)
+		if err != nil {
+			return err
+		}
+
+		ctx.tableCtx.caption = res
+
+	case atom.Thead:
+		ctx.tableCtx.isInHeader = true
+		if err := ctx.traverseChildren(node); err != nil {
+			return err
+		}
+		ctx.tableCtx.isInHeader = false
+
 	case atom.Tfoot:
 		ctx.tableCtx.isInFooter = true
 		if err := ctx.traverseChildren(node); err != nil {
@@ -379,30 +2541,48 @@ func (ctx *textifyTraverseContext) handleTableElement(node *html.Node) error {
 		ctx.tableCtx.isInFooter = false
 
 	case atom.Tr:
+		ctx.tableCtx.closeImplicitRow()
 		ctx.tableCtx.body = append(ctx.tableCtx.body, []string{})
+		if ctx.tableCtx.isInFooter {
+			ctx.tableCtx.footer = append(ctx.tableCtx.footer, []string{})
+		}
 		if err := ctx.traverseChildren(node); err != nil {
 			return err
 		}
+		if len(ctx.tableCtx.body[ctx.tableCtx.tmpRow]) > 0 {
+			ctx.tableCtx.fillSpannedCells(true)
+		}
 		ctx.tableCtx.tmpRow++
 
-	case atom.Th:
+	case atom.Th, atom.Td:
 		res, err := ctx.renderEachChild(node)
 		if err != nil {
 			return err
 		}
+		ctx.tableCtx.openImplicitRow()
 
-		ctx.tableCtx.header = append(ctx.tableCtx.header, res)
-
-	case atom.Td:
-		res, err := ctx.renderEachChild(node)
-		if err != nil {
-			return err
-		}
+		// Spanned columns are filled with empty cells to keep columns aligned.
+		cells := append([]string{res}, make([]string, getSpanAttrVal(node, "colspan")-1)...)
 
-		if ctx.tableCtx.isInFooter {
-			ctx.tableCtx.footer = append(ctx.tableCtx.footer, res)
-		} else {
-			ctx.tableCtx.body[ctx.tableCtx.tmpRow] = append(ctx.tableCtx.body[ctx.tableCtx.tmpRow], res)
+		isHeaderCell := node.DataAtom == atom.Th || ctx.tableCtx.isInHeader
+		switch {
+		case ctx.tableCtx.isInFooter:
+			if len(ctx.tableCtx.footer) == 0 {
+				ctx.tableCtx.footer = append(ctx.tableCtx.footer, []string{})
+			}
+			last := len(ctx.tableCtx.footer) - 1
+			ctx.tableCtx.footer[last] = append(ctx.tableCtx.footer[last], cells...)
+		case isHeaderCell && ctx.tableCtx.isHeaderRow():
+			// Header cells spanning rows cover the columns of the first body rows.
+			col := len(ctx.tableCtx.header)
+			ctx.tableCtx.spanRows(col, len(cells), getSpanAttrVal(node, "rowspan"))
+			ctx.tableCtx.header = append(ctx.tableCtx.header, cells...)
+			ctx.tableCtx.headerRow = ctx.tableCtx.tmpRow
+		default:
+			ctx.tableCtx.fillSpannedCells(false)
+			col := len(ctx.tableCtx.body[ctx.tableCtx.tmpRow])
+			ctx.tableCtx.spanRows(col, len(cells), getSpanAttrVal(node, "rowspan"))
+			ctx.tableCtx.body[ctx.tableCtx.tmpRow] = append(ctx.tableCtx.body[ctx.tableCtx.tmpRow], cells...)
 		}
 
 	}
@@ -415,24 +2595,115 @@ func (ctx *textifyTraverseContext) traverse(node *html.Node) error {
 		return ctx.traverseChildren(node)
 
 	case html.TextNode:
-		var data string
-		if ctx.isPre {
-			data = node.Data
+		// Soft hyphens are hints for justified text only.
+		data := strings.ReplaceAll(node.Data, "\u00AD", "")
+		if ctx.options.NormalizeSpaces {
+			data = spaceNormalizer.Replace(data)
+		}
+		if ctx.options.StripEmoji {
+			data = stripSymbols(data)
 		} else {
-			data = strings.TrimSpace(spacingRe.ReplaceAllString(node.Data, " "))
+			data = replaceEmoji(data, ctx.options.EmojiMode)
+		}
+		if ctx.options.AutolinkBareURLs && !ctx.isInLink && !ctx.isPre && strings.Contains(data, "://") {
+			data = bareURLRe.ReplaceAllString(data, "<$0>")
+		}
+		if ctx.options.PreserveWhitespace {
+			// The text carries its own spacing, don't add any.
+			return ctx.hug(ctx.transformText(data))
+		}
+		if !ctx.isPre {
+			// Spacing after a word break opportunity separates words again.
+			if (ctx.atWordBreak || ctx.gluesNext) && strings.IndexFunc(data, unicode.IsSpace) == 0 {
+				ctx.endsWithSpace, ctx.atWordBreak, ctx.gluesNext = false, false, false
+			}
+			// Punctuation only attaches to the preceding word when nothing
+			// separates them in the document, unlike the delimiters of elements.
+			ctx.spacedText, ctx.isText = strings.IndexFunc(data, unicode.IsSpace) == 0, true
+			defer func() { ctx.spacedText, ctx.isText = false, false }()
+			last, _ := utf8.DecodeLastRuneInString(data)
+			opens := strings.ContainsRune(openingPunctuation, last)
+			data = strings.TrimSpace(spacingRe.ReplaceAllString(data, " "))
+			if err := ctx.emit(ctx.transformText(data)); err != nil {
+				return err
+			}
+			if opens {
+				// The following word attaches to the opening bracket.
+				ctx.endsWithSpace, ctx.gluesNext = true, true
+			}
+			return nil
 		}
-		return ctx.emit(data)
+		return ctx.emit(ctx.transformText(data))
 
 	case html.ElementNode:
+		ctx.depth++
+		defer func() { ctx.depth-- }()
+		if maxDepth := ctx.maxDepth(); maxDepth > 0 && ctx.depth > maxDepth {
+			return ErrMaxDepthExceeded
+		}
+		if hasAttr(node, "lang") {
+			lang := ctx.lang
+			ctx.lang = strings.TrimSpace(getAttrVal(node, "lang"))
+			defer func() { ctx.lang = lang }()
+		}
 		return ctx.handleElement(node)
 	}
 }
 
+// toUpper uppercases s following the rules of the language of the current
+// element, language-neutral ones when unknown.
+func (ctx *textifyTraverseContext) toUpper(s string) string {
+	tag, err := language.Parse(ctx.lang)
+	if err != nil {
+		tag = language.Und
+	}
+	return cases.Upper(tag).String(s)
+}
+
+// defaultMaxDepth is the element nesting depth rendering gives up at when
+// Options.MaxDepth is zero.
+const defaultMaxDepth = 1000
+
+// maxDepth returns the element nesting depth rendering gives up at, or zero
+// when unlimited.
+func (ctx *textifyTraverseContext) maxDepth() int {
+	switch {
+	case ctx.options.MaxDepth > 0:
+		return ctx.options.MaxDepth
+	case ctx.options.MaxDepth < 0:
+		return 0
+	}
+	return defaultMaxDepth
+}
+
+// transformText applies the TextTransform function to the text, if any.
+func (ctx *textifyTraverseContext) transformText(text string) string {
+	if ctx.options.TextTransform == nil || text == "" {
+		return text
+	}
+	return ctx.options.TextTransform(text)
+}
+
+// cancelCheckInterval is the number of visited nodes between two checks of
+// the cancellation context.
+const cancelCheckInterval = 64
+
 func (ctx *textifyTraverseContext) traverseChildren(node *html.Node) error {
 	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		*ctx.visited++
+		if ctx.cancelCtx != nil && *ctx.visited%cancelCheckInterval == 0 {
+			if err := ctx.cancelCtx.Err(); err != nil {
+				return err
+			}
+		}
 		if err := ctx.traverse(c); err != nil {
 			return err
 		}
+		if ctx.out != nil {
+			if err := ctx.flush(); err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -442,74 +2713,173 @@ func (ctx *textifyTraverseContext) emit(data string) error {
 	if data == "" {
 		return nil
 	}
+	first, _ := utf8.DecodeRuneInString(data)
 	var (
-		lines = ctx.breakLongLines(data)
-		err   error
+		// Whether a space separates data from the previous text.
+		spaced = !unicode.IsSpace(first) && !ctx.endsWithSpace && !ctx.isPre && (ctx.spacedText || !ctx.isText || !startsWithClosingPunctuation(data))
+		lines  = ctx.breakLongLines(data, spaced)
+		err    error
 	)
-	for _, line := range lines {
-		runes := []rune(line)
-		startsWithSpace := unicode.IsSpace(runes[0])
-		if !startsWithSpace && !ctx.endsWithSpace && !strings.HasPrefix(data, ".") {
+	ctx.atWordBreak, ctx.gluesNext = false, false
+	for i, line := range lines {
+		first, _ := utf8.DecodeRuneInString(line)
+		if i == 0 && spaced && !unicode.IsSpace(first) {
 			if err = ctx.buf.WriteByte(' '); err != nil {
 				return err
 			}
 			ctx.lineLength++
 		}
-		ctx.endsWithSpace = unicode.IsSpace(runes[len(runes)-1])
-		for _, c := range line {
-			if _, err = ctx.buf.WriteString(string(c)); err != nil {
+		last, _ := utf8.DecodeLastRuneInString(line)
+		ctx.endsWithSpace = unicode.IsSpace(last)
+		// Write the line a piece at a time, up to each newline followed by
+		// the prefix.
+		for {
+			i := strings.IndexByte(line, '\n')
+			if i < 0 {
+				if _, err = ctx.buf.WriteString(line); err != nil {
+					return err
+				}
+				ctx.lineLength += utf8.RuneCountInString(line)
+				break
+			}
+			if _, err = ctx.buf.WriteString(line[:i+1]); err != nil {
 				return err
 			}
-			ctx.lineLength++
-			if c == '\n' {
-				ctx.lineLength = 0
-				if ctx.prefix != "" {
-					if _, err = ctx.buf.WriteString(ctx.prefix); err != nil {
-						return err
-					}
+			ctx.lineLength = 0
+			if ctx.prefix != "" {
+				if _, err = ctx.buf.WriteString(ctx.prefix); err != nil {
+					return err
 				}
 			}
+			line = line[i+1:]
 		}
 	}
 	return nil
 }
 
+// annotate emits data generated from an element, such as a link target,
+// separated from the previous text even within preformatted text, where
+// emit adds no spacing.
+func (ctx *textifyTraverseContext) annotate(data string) error {
+	if ctx.isPre && !ctx.endsWithSpace && data != "" {
+		data = " " + data
+	}
+	return ctx.emit(data)
+}
+
+// emitUnwrapped emits data without breaking long lines, for laid out text
+// such as table grids and heading dividers.
+func (ctx *textifyTraverseContext) emitUnwrapped(data string) error {
+	noWrap := ctx.noWrap
+	ctx.noWrap = true
+	err := ctx.emit(data)
+	ctx.noWrap = noWrap
+	return err
+}
+
+const (
+	// closingPunctuation are the characters attaching to the preceding word.
+	closingPunctuation = ".,;:!?)]}"
+	// openingPunctuation are the characters attaching to the following word.
+	openingPunctuation = "([{"
+)
+
+// startsWithClosingPunctuation reports whether data starts with punctuation
+// attaching to the preceding word.
+func startsWithClosingPunctuation(data string) bool {
+	return data != "" && strings.ContainsRune(closingPunctuation, rune(data[0]))
+}
+
 const maxLineLen = 74
 
-func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
-	// Only break lines when in blockquotes.
-	if ctx.blockquoteLevel == 0 {
+// minDividerLen is the length horizontal rules are never shortened below.
+const minDividerLen = 3
+
+// lineWidth returns the width long lines are broken at.
+func (ctx *textifyTraverseContext) lineWidth() int {
+	if ctx.options.LineWidth > 0 {
+		return ctx.options.LineWidth
+	}
+	return maxLineLen
+}
+
+// breakLongLines splits data into lines not exceeding the line width, data
+// being separated from the previous text by a space when spaced is set.
+func (ctx *textifyTraverseContext) breakLongLines(data string, spaced bool) []string {
+	// Only break lines when in blockquotes, or anywhere but in preformatted
+	// text when wrapping is on.
+	if ctx.noWrap || ctx.blockquoteLevel == 0 && (!ctx.options.WrapText || ctx.isPre) {
 		return []string{data}
 	}
 	var (
-		ret      []string
-		runes    = []rune(data)
-		l        = len(runes)
-		existing = ctx.lineLength
+		ret         []string
+		existing    = ctx.lineLength
+		atWordBreak = ctx.atWordBreak || spaced
+	)
+	if spaced {
+		existing++
+	}
+	for _, line := range strings.SplitAfter(data, "\n") {
+		if line != "" {
+			ret = append(ret, ctx.breakLongLine(line, existing, atWordBreak)...)
+		}
+		existing, atWordBreak = 0, false
+	}
+	return ret
+}
+
+// breakLongLine splits a line of data into lines not exceeding the line
+// width, existing being the length of the line it continues. atWordBreak
+// reports whether the line may break in front of data. Wrapped lines start
+// with the hanging indent.
+func (ctx *textifyTraverseContext) breakLongLine(data string, existing int, atWordBreak bool) []string {
+	var (
+		ret        []string
+		newline    = strings.HasSuffix(data, "\n")
+		runes      = []rune(strings.TrimSuffix(data, "\n"))
+		l          = len(runes)
+		maxLineLen = ctx.lineWidth()
+		indent     = ctx.indent
 	)
-	if existing >= maxLineLen {
-		ret = append(ret, "\n")
-		existing = 0
+	if indent >= maxLineLen {
+		indent = 0
 	}
-	for l+existing > maxLineLen {
+	lineBreak := "\n" + strings.Repeat(" ", indent)
+	if existing >= maxLineLen && l > 0 {
+		ret = append(ret, lineBreak)
+		existing = indent
+	}
+	for l > 0 && l+existing > maxLineLen {
 		i := maxLineLen - existing
-		for i >= 0 && !unicode.IsSpace(runes[i]) {
+		for i >= 0 && !isLineBreak(runes, i) {
 			i--
 		}
+		if i == -1 && atWordBreak && existing > indent {
+			// Break at the word break opportunity preceding data.
+			ret = append(ret, lineBreak)
+			existing, atWordBreak = indent, false
+			continue
+		}
 		if i == -1 {
 			// No spaces, so go the other way.
 			i = maxLineLen - existing
-			for i < l && !unicode.IsSpace(runes[i]) {
+			for i < l && !isLineBreak(runes, i) {
 				i++
 			}
 		}
-		ret = append(ret, string(runes[:i])+"\n")
+		if i == l {
+			break
+		}
+		ret = append(ret, string(runes[:i])+lineBreak)
 		for i < l && unicode.IsSpace(runes[i]) {
 			i++
 		}
 		runes = runes[i:]
 		l = len(runes)
-		existing = 0
+		existing = indent
+	}
+	if newline {
+		runes = append(runes, '\n')
 	}
 	if len(runes) > 0 {
 		ret = append(ret, string(runes))
@@ -517,26 +2887,109 @@ func (ctx *textifyTraverseContext) breakLongLines(data string) []string {
 	return ret
 }
 
+// isLineBreak reports whether a line may break at the rune at index i, a
+// space not holding link delimiters apart from the link.
+func isLineBreak(runes []rune, i int) bool {
+	return unicode.IsSpace(runes[i]) && (i == 0 || runes[i-1] != '(') && (i+1 == len(runes) || runes[i+1] != ')')
+}
+
+// isOmittedLink reports whether link is a fragment omitted by
+// OmitFragmentLinks, or whether its host is one of the OmitLinkDomains or a
+// subdomain of one of them.
+func (ctx *textifyTraverseContext) isOmittedLink(link string) bool {
+	if ctx.options.OmitFragmentLinks && strings.HasPrefix(link, "#") {
+		return true
+	}
+	if len(ctx.options.OmitLinkDomains) == 0 {
+		return false
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return false
+	}
+	for _, domain := range ctx.options.OmitLinkDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain != "" && (host == domain || strings.HasSuffix(host, "."+domain)) {
+			return true
+		}
+	}
+	return false
+}
+
+// shortenLink cuts the middle of links longer than maxLen runes out, replacing
+// it with an ellipsis. Zero maxLen disables shortening.
+func shortenLink(link string, maxLen int) string {
+	const ellipsis = "..."
+	runes := []rune(link)
+	if maxLen <= 0 || len(runes) <= maxLen {
+		return link
+	}
+	if maxLen <= len(ellipsis) {
+		return string(runes[:maxLen])
+	}
+	tail := (maxLen - len(ellipsis)) / 2
+	head := maxLen - len(ellipsis) - tail
+	return string(runes[:head]) + ellipsis + string(runes[len(runes)-tail:])
+}
+
 func (ctx *textifyTraverseContext) normalizeHrefLink(link string) string {
+	if ctx.options.LinkNormalizer != nil {
+		return ctx.options.LinkNormalizer(link)
+	}
 	link = strings.TrimSpace(link)
-	link = strings.TrimPrefix(link, "mailto:")
+	if ctx.options.BaseURL != "" && !strings.HasPrefix(link, "#") {
+		link = resolveLink(ctx.options.BaseURL, link)
+	}
+	if !ctx.options.KeepMailtoPrefix {
+		link = strings.TrimPrefix(link, "mailto:")
+	}
+	if ctx.options.StripTelPrefix {
+		link = strings.TrimPrefix(link, "tel:")
+	}
 	return link
 }
 
+// resolveLink resolves link against the base URL, returning it untouched when
+// either is invalid.
+func resolveLink(base, link string) string {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return link
+	}
+	linkURL, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	return baseURL.ResolveReference(linkURL).String()
+}
+
 // renderEachChild visits each direct child of a node and collects the sequence of
-// textual representations separated by a single newline.
+// textual representations separated by a single newline. Line breaks between
+// children stand for that separator.
 func (ctx *textifyTraverseContext) renderEachChild(node *html.Node) (string, error) {
 	buf := &bytes.Buffer{}
 	for c := node.FirstChild; c != nil; c = c.NextSibling {
-		s, err := FromHTMLNode(c, ctx.options)
-		if err != nil {
+		if c.DataAtom == atom.Br {
+			if err := buf.WriteByte('\n'); err != nil {
+				return "", err
+			}
+			continue
+		}
+		subCtx := ctx.subContext()
+		if err := subCtx.traverse(c); err != nil {
 			return "", err
 		}
-		if _, err = buf.WriteString(s); err != nil {
+		text := subCtx.text()
+		subCtx.release()
+		if _, err := buf.WriteString(text); err != nil {
 			return "", err
 		}
-		if c.NextSibling != nil {
-			if err = buf.WriteByte('\n'); err != nil {
+		if c.NextSibling != nil && c.NextSibling.DataAtom != atom.Br {
+			if err := buf.WriteByte('\n'); err != nil {
 				return "", err
 			}
 		}
@@ -544,6 +2997,94 @@ func (ctx *textifyTraverseContext) renderEachChild(node *html.Node) (string, err
 	return buf.String(), nil
 }
 
+// hug emits data right after the previous text, without the separating space
+// emit would otherwise insert.
+func (ctx *textifyTraverseContext) hug(data string) error {
+	endsWithSpace := ctx.endsWithSpace
+	ctx.endsWithSpace = true
+	err := ctx.emit(data)
+	if data == "" {
+		ctx.endsWithSpace = endsWithSpace
+	}
+	return err
+}
+
+var (
+	superscripts = map[rune]rune{
+		'0': '⁰', '1': '¹', '2': '²', '3': '³', '4': '⁴',
+		'5': '⁵', '6': '⁶', '7': '⁷', '8': '⁸', '9': '⁹',
+		'+': '⁺', '-': '⁻', '=': '⁼', '(': '⁽', ')': '⁾',
+		'i': 'ⁱ', 'n': 'ⁿ',
+	}
+	subscripts = map[rune]rune{
+		'0': '₀', '1': '₁', '2': '₂', '3': '₃', '4': '₄',
+		'5': '₅', '6': '₆', '7': '₇', '8': '₈', '9': '₉',
+		'+': '₊', '-': '₋', '=': '₌', '(': '₍', ')': '₎',
+		'a': 'ₐ', 'e': 'ₑ', 'h': 'ₕ', 'k': 'ₖ', 'l': 'ₗ',
+		'm': 'ₘ', 'n': 'ₙ', 'o': 'ₒ', 'p': 'ₚ', 's': 'ₛ',
+		't': 'ₜ', 'x': 'ₓ',
+	}
+)
+
+// mapRunes replaces every rune of str using the mapping, reporting false if
+// any of them has no replacement.
+func mapRunes(str string, mapping map[rune]rune) (string, bool) {
+	runes := []rune(str)
+	for i, r := range runes {
+		mapped, ok := mapping[r]
+		if !ok {
+			return "", false
+		}
+		runes[i] = mapped
+	}
+	return string(runes), true
+}
+
+// maxSpan is the largest colspan or rowspan honored, as per the HTML spec.
+const maxSpan = 1000
+
+// getSpanAttrVal returns the number of columns or rows a table cell spans,
+// defaulting to 1 for missing or invalid values.
+func getSpanAttrVal(node *html.Node, attrName string) int {
+	span, err := strconv.Atoi(strings.TrimSpace(getAttrVal(node, attrName)))
+	if err != nil || span < 1 {
+		return 1
+	}
+	if span > maxSpan {
+		return maxSpan
+	}
+	return span
+}
+
+// getCodeChild returns the <code> element making up the whole content of the
+// node, if any.
+func getCodeChild(node *html.Node) *html.Node {
+	var code *html.Node
+	for c := node.FirstChild; c != nil; c = c.NextSibling {
+		switch {
+		case c.Type == html.TextNode && strings.TrimSpace(c.Data) == "":
+		case c.DataAtom == atom.Code && code == nil:
+			code = c
+		default:
+			return nil
+		}
+	}
+	return code
+}
+
+// getClassLanguage returns the language named by a "language-*" or "lang-*"
+// class of the node.
+func getClassLanguage(node *html.Node) string {
+	for _, class := range strings.Fields(getAttrVal(node, "class")) {
+		for _, prefix := range []string{"language-", "lang-"} {
+			if strings.HasPrefix(class, prefix) {
+				return strings.TrimPrefix(class, prefix)
+			}
+		}
+	}
+	return ""
+}
+
 func getAttrVal(node *html.Node, attrName string) string {
 	for _, attr := range node.Attr {
 		if attr.Key == attrName {
@@ -553,3 +3094,80 @@ func getAttrVal(node *html.Node, attrName string) string {
 
 	return ""
 }
+
+// classStyleWrapper returns the ClassStyleMap wrapper of the first class of
+// the element found in the map.
+func (ctx *textifyTraverseContext) classStyleWrapper(node *html.Node) string {
+	if len(ctx.options.ClassStyleMap) == 0 {
+		return ""
+	}
+	for _, class := range strings.Fields(getAttrVal(node, "class")) {
+		if wrapper := ctx.options.ClassStyleMap[class]; wrapper != "" {
+			return wrapper
+		}
+	}
+	return ""
+}
+
+// isAllowed reports whether the element may be rendered as per AllowedAtoms.
+// The document structure and the elements whose content is never rendered
+// are always allowed.
+func (ctx *textifyTraverseContext) isAllowed(a atom.Atom) bool {
+	if len(ctx.options.AllowedAtoms) == 0 {
+		return true
+	}
+	switch a {
+	case atom.Html, atom.Body, atom.Head, atom.Script, atom.Style:
+		return true
+	}
+	for _, allowed := range ctx.options.AllowedAtoms {
+		if a == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// isSkipped reports whether the element matches any of the SkipSelectors.
+func (ctx *textifyTraverseContext) isSkipped(node *html.Node) bool {
+	for _, selector := range ctx.options.SkipSelectors {
+		selector = strings.TrimSpace(selector)
+		if len(selector) < 2 {
+			continue
+		}
+		switch selector[0] {
+		case '#':
+			if getAttrVal(node, "id") == selector[1:] {
+				return true
+			}
+		case '.':
+			for _, class := range strings.Fields(getAttrVal(node, "class")) {
+				if class == selector[1:] {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isHidden reports whether the element is marked hidden, either with the hidden
+// attribute or by its inline style.
+func isHidden(node *html.Node) bool {
+	if hasAttr(node, "hidden") {
+		return true
+	}
+	style := strings.ToLower(strings.Join(strings.Fields(getAttrVal(node, "style")), ""))
+	return strings.Contains(style, "display:none") || strings.Contains(style, "visibility:hidden")
+}
+
+// hasAttr reports whether the node has the attribute, whatever its value.
+func hasAttr(node *html.Node, attrName string) bool {
+	for _, attr := range node.Attr {
+		if attr.Key == attrName {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,12 +2,23 @@ package html2text
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
 	"path"
 	"regexp"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"text/template"
+	"unicode/utf16"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
 )
 
 const destPath = "testdata"
@@ -61,35 +72,274 @@ func TestParseUTF8(t *testing.T) {
 	}
 }
 
-func TestStrippingWhitespace(t *testing.T) {
+func TestReaderByteOrderMarks(t *testing.T) {
+	encodeUTF16 := func(s string, order binary.ByteOrder) []byte {
+		units := utf16.Encode([]rune(s))
+		bs := make([]byte, 2*len(units))
+		for i, unit := range units {
+			order.PutUint16(bs[2*i:], unit)
+		}
+		return bs
+	}
+	input := "<p>Caf\u00e9 \U0001F600</p>"
+	testCases := []struct {
+		name  string
+		input []byte
+	}{
+		{"no BOM", []byte(input)},
+		{"UTF-8", append([]byte{0xEF, 0xBB, 0xBF}, input...)},
+		{"UTF-16LE", append([]byte{0xFF, 0xFE}, encodeUTF16(input, binary.LittleEndian)...)},
+		{"UTF-16BE", append([]byte{0xFE, 0xFF}, encodeUTF16(input, binary.BigEndian)...)},
+	}
+
+	for _, testCase := range testCases {
+		// Deliver the input a byte at a time, splitting the BOM across reads.
+		text, err := FromReader(iotest.OneByteReader(bytes.NewReader(testCase.input)))
+		if err != nil {
+			t.Errorf("%s: %v", testCase.name, err)
+		} else if want := "Caf\u00e9 \U0001F600"; text != want {
+			t.Errorf("%s: expected %q, got %q", testCase.name, want, text)
+		}
+	}
+}
+
+func TestFromReaderToWriter(t *testing.T) {
+	for _, file := range []string{"utf8.html", "utf8_with_bom.xhtml"} {
+		bs, err := os.ReadFile(path.Join(destPath, file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, err := FromReader(bytes.NewReader(bs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := &bytes.Buffer{}
+		if err := FromReaderToWriter(bytes.NewReader(bs), buf); err != nil {
+			t.Fatal(err)
+		}
+		if buf.String() != want {
+			t.Fatalf("output for file %s differs from FromReader", file)
+		}
+	}
+
+	// Large documents are written out a part at a time.
+	input := strings.Repeat(`<h1>Title</h1><div>Some <a href="http://example.com/">link</a></div><blockquote><p>Quoted</p></blockquote><p></p><br><br><br>text`, 500)
+	for _, options := range []Options{{}, {LinkFootnotes: true}, {DropEmptyBlocks: true, MaxConsecutiveNewlines: 1}, {TrailingNewline: true, LineEnding: "\r\n"}} {
+		want, err := FromString(input, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer := &countingWriter{}
+		if err := FromReaderToWriter(strings.NewReader(input), writer, options); err != nil {
+			t.Fatal(err)
+		}
+		if writer.buf.String() != want {
+			t.Errorf("output with options %+v differs from FromReader", options)
+		}
+		if writer.writes < 2 {
+			t.Errorf("expected the output with options %+v to be written in parts, got %d writes", options, writer.writes)
+		}
+	}
+}
+
+// countingWriter records the data written to it along with the number of
+// writes.
+type countingWriter struct {
+	buf    bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func TestFromReaderWithContext(t *testing.T) {
+	input := strings.Repeat("<div><p>Test <b>text</b></p></div>", 1000)
+
+	text, err := FromReaderWithContext(context.Background(), strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := FromString(input); text != want {
+		t.Fatal("output differs from FromString")
+	}
+
+	cancelCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := FromReaderWithContext(cancelCtx, strings.NewReader(input)); err != context.Canceled {
+		t.Fatalf("expected context.Canceled error, got %v", err)
+	}
+
+	// Cancelling while rendering stops the rendering.
+	cancelCtx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	transforms := 0
+	options := Options{TextTransform: func(text string) string {
+		if transforms++; transforms == 10 {
+			cancel()
+		}
+		return text
+	}}
+	if _, err := FromReaderWithContext(cancelCtx, strings.NewReader(input), options); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled error, got %v", err)
+	}
+	if transforms >= 2000 {
+		t.Fatalf("expected rendering to stop early, got %d text transforms", transforms)
+	}
+
+	// Nested inline elements, each rendered apart, count towards the checks.
+	cancelCtx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	transforms = 0
+	options = Options{MaxDepth: -1, TextTransform: func(text string) string {
+		if transforms++; transforms == 1 {
+			cancel()
+		}
+		return text
+	}}
+	nested := strings.Repeat("<b>t", 900)
+	if _, err := FromReaderWithContext(cancelCtx, strings.NewReader(nested), options); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled error for nested elements, got %v", err)
+	}
+	if transforms >= 900 {
+		t.Fatalf("expected rendering of nested elements to stop early, got %d text transforms", transforms)
+	}
+}
+
+func TestFromStringWithStats(t *testing.T) {
+	testCases := []struct {
+		input   string
+		stats   Stats
+		options Options
+	}{
+		{
+			"",
+			Stats{},
+			Options{},
+		},
+		{
+			`<p>Hello <a href="http://example.com/">world</a></p><p>Bye <a>nowhere</a></p>`,
+			Stats{Lines: 3, MaxLineWidth: 35, LinkCount: 1},
+			Options{},
+		},
+		{
+			`<table><tr><td>cell1</td><td>cell2</td></tr></table><table><tr><td><a href="/">x</a></td></tr></table>`,
+			Stats{Lines: 7, MaxLineWidth: 17, LinkCount: 1, TableCount: 2},
+			Options{PrettyTables: true},
+		},
+		{
+			`<p>Hello <a href="http://example.com/">world</a></p>`,
+			Stats{Lines: 3, MaxLineWidth: 23, LinkCount: 1},
+			Options{LinkFootnotes: true, TrailingNewline: true},
+		},
+		// UTF-16LE with a byte order mark.
+		{
+			"\xff\xfe<\x00p\x00>\x00h\x00i\x00<\x00/\x00p\x00>\x00",
+			Stats{Lines: 1, MaxLineWidth: 2},
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		text, stats, err := FromStringWithStats(testCase.input, testCase.options)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if want, _ := FromString(testCase.input, testCase.options); text != want {
+			t.Errorf("output %q differs from FromString output %q", text, want)
+		}
+		if stats != testCase.stats {
+			t.Errorf("input %q: expected stats %+v, got %+v", testCase.input, testCase.stats, stats)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	input := strings.Repeat("<div>", 5000) + "Test" + strings.Repeat("</div>", 5000)
+
+	if _, err := FromString(input, Options{MaxDepth: 100}); err != ErrMaxDepthExceeded {
+		t.Fatalf("expected ErrMaxDepthExceeded error, got %v", err)
+	}
+	if _, err := FromString(input); err != ErrMaxDepthExceeded {
+		t.Fatalf("expected ErrMaxDepthExceeded error by default, got %v", err)
+	}
+	if text, err := FromString(input, Options{MaxDepth: -1}); err != nil || text != "Test" {
+		t.Fatalf("expected unlimited depth to render %q, got %q, %v", "Test", text, err)
+	}
+	doc, err := html.Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StructuredFromHTMLNode(doc, Options{MaxDepth: 100}); err != ErrMaxDepthExceeded {
+		t.Fatalf("expected ErrMaxDepthExceeded error from blocks, got %v", err)
+	}
+	tmpl := template.Must(template.New("block").Parse("{{.Text}}\n"))
+	if _, err := FromString(input, Options{MaxDepth: 100, Template: tmpl}); err != ErrMaxDepthExceeded {
+		t.Fatalf("expected ErrMaxDepthExceeded error from template, got %v", err)
+	}
+
+	// Subcontexts share the depth of their parent.
+	input = strings.Repeat("<b>", 50) + "Test" + strings.Repeat("</b>", 50)
+	if _, err := FromString(input, Options{MaxDepth: 20}); err != ErrMaxDepthExceeded {
+		t.Fatalf("expected ErrMaxDepthExceeded error, got %v", err)
+	}
+}
+
+func TestSoftHyphens(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"test text",
-			"test text",
+			"<p>Extra&shy;ordinary hyphen&shy;ation</p>",
+			"Extraordinary hyphenation",
 		},
 		{
-			"  \ttext\ntext\n",
-			"text text",
+			"<p>Extra\u00ADordinary <b>hyphen\u00ADation</b></p>",
+			"Extraordinary *hyphenation*",
 		},
 		{
-			"  \na \n\t \n \n a \t",
-			"a a",
+			"<pre>Extra&shy;ordinary</pre>",
+			"Extraordinary",
 		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLineEnding(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
 		{
-			"test        text",
-			"test text",
+			"<p>Line 1<br>Line 2</p><p>Line 3</p>",
+			"Line 1\r\nLine 2\r\n\r\nLine 3",
+			Options{LineEnding: "\r\n"},
 		},
 		{
-			"test&nbsp;&nbsp;&nbsp; text&nbsp;",
-			"test    text",
+			"<blockquote>Lorem ipsum Commodo id consectetur pariatur</blockquote>",
+			"> \r\n> Lorem ipsum Commodo id\r\n> consectetur pariatur",
+			Options{LineEnding: "\r\n", LineWidth: 24},
+		},
+		{
+			`<p><a href="http://example.com/">Link</a></p>`,
+			"Link [1]\r\n\r\n[1] http://example.com/\r\n",
+			Options{LineEnding: "\r\n", LinkFootnotes: true, TrailingNewline: true},
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -97,50 +347,79 @@ func TestStrippingWhitespace(t *testing.T) {
 	}
 }
 
-func TestParagraphsAndBreaks(t *testing.T) {
+func TestAdjacentInlineElements(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"Test text",
-			"Test text",
+			`<a>foo</a> <a>bar</a> <a>baz</a>`,
+			"foo bar baz",
 		},
 		{
-			"Test text<br>",
-			"Test text",
+			`<a href="http://example.com/">foo</a> <a>bar</a><a>baz</a>`,
+			"foo ( http://example.com/ ) bar baz",
 		},
 		{
-			"Test text<br>Test",
-			"Test text\nTest",
+			`<span>foo</span> <span>bar</span>
+				<a><span>baz</span></a>`,
+			"foo bar baz",
 		},
 		{
-			"<p>Test text</p>",
-			"Test text",
+			`<a>foo</a> <b></b> <i></i> <a>bar</a>`,
+			"foo bar",
 		},
 		{
-			"<p>Test text</p><p>Test text</p>",
-			"Test text\n\nTest text",
+			`<a>foo</a><wbr> <a>bar</a> foo<wbr> bar foo<wbr>bar`,
+			"foo bar foo bar foobar",
 		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestClosingPunctuation(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
 		{
-			"\n<p>Test text</p>\n\n\n\t<p>Test text</p>\n",
-			"Test text\n\nTest text",
+			"<p>Apples, <b>pears</b>, and plums</p>",
+			"Apples, *pears*, and plums",
 		},
 		{
-			"\n<p>Test text<br/>Test text</p>\n",
-			"Test text\nTest text",
+			"<p>Fruits (like <i>apples</i>) are good; <b>pears</b>: better!</p>",
+			"Fruits (like _apples_) are good; *pears*: better!",
 		},
 		{
-			"\n<p>Test text<br> \tTest text<br></p>\n",
-			"Test text\nTest text",
+			"<p>Really <b>good</b>?</p>",
+			"Really *good*?",
 		},
 		{
-			"Test text<br><BR />Test text",
-			"Test text\n\nTest text",
+			`<p>See <a href="http://example.com/">this</a>, then stop</p>`,
+			"See this ( http://example.com/ ), then stop",
 		},
 		{
-			"<pre>test1\ntest 2\n\ntest  3</pre>",
-			"test1\ntest 2\n\ntest  3",
+			"<p>Some <mark>highlighted</mark> text</p>",
+			"Some ==highlighted== text",
+		},
+		{
+			"<p>This is <b>great</b>!! Isn't it <i>now</i>??</p>",
+			"This is *great*!! Isn't it _now_??",
+		},
+		{
+			"<p>Some (<b>bold</b>) and [<i>italic</i>] text</p>",
+			"Some (*bold*) and [_italic_] text",
+		},
+		{
+			"<p>Spaced ( <b>bold</b> ) text</p>",
+			"Spaced ( *bold* ) text",
 		},
 	}
 
@@ -151,97 +430,416 @@ func TestParagraphsAndBreaks(t *testing.T) {
 			t.Log(msg)
 		}
 	}
+
+	if msg, err := wantString("<p>Some <mark>highlighted</mark> text</p>", "Some !!highlighted!! text", Options{MarkDelimiter: "!!"}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+
+	// Spacing of the document is kept in front of punctuation.
+	if msg, err := wantString("<p><b>Great</b> 😄</p>", "*Great* :smile:", Options{EmojiMode: EmojiShortcode}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	if msg, err := wantString("<p><b>Great</b> :)</p>", "*Great* :)"); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
 }
 
-func TestTables(t *testing.T) {
+func TestStrippingWhitespace(t *testing.T) {
 	testCases := []struct {
-		input           string
-		tabularOutput   string
-		plaintextOutput string
+		input  string
+		output string
 	}{
 		{
-			"<table><tr><td></td><td></td></tr></table>",
-			// Empty table
-			// +--+--+
-			// |  |  |
-			// +--+--+
-			"+--+--+\n|  |  |\n+--+--+",
-			"",
+			"test text",
+			"test text",
 		},
 		{
-			"<table><tr><td>cell1</td><td>cell2</td></tr></table>",
-			// +-------+-------+
-			// | cell1 | cell2 |
-			// +-------+-------+
-			"+-------+-------+\n| cell1 | cell2 |\n+-------+-------+",
-			"cell1 cell2",
+			"  \ttext\ntext\n",
+			"text text",
 		},
 		{
-			"<table><tr><td>row1</td></tr><tr><td>row2</td></tr></table>",
-			// +------+
-			// | row1 |
-			// | row2 |
-			// +------+
-			"+------+\n| row1 |\n| row2 |\n+------+",
-			"row1 row2",
+			"  \na \n\t \n \n a \t",
+			"a a",
 		},
 		{
-			`<table>
-				<tbody>
-					<tr><td><p>Row-1-Col-1-Msg123456789012345</p><p>Row-1-Col-1-Msg2</p></td><td>Row-1-Col-2</td></tr>
-					<tr><td>Row-2-Col-1</td><td>Row-2-Col-2</td></tr>
-				</tbody>
-			</table>`,
-			// +--------------------------------+-------------+
-			// | Row-1-Col-1-Msg123456789012345 | Row-1-Col-2 |
-			// | Row-1-Col-1-Msg2               |             |
-			// | Row-2-Col-1                    | Row-2-Col-2 |
-			// +--------------------------------+-------------+
-			`+--------------------------------+-------------+
-| Row-1-Col-1-Msg123456789012345 | Row-1-Col-2 |
-| Row-1-Col-1-Msg2               |             |
-| Row-2-Col-1                    | Row-2-Col-2 |
-+--------------------------------+-------------+`,
-			`Row-1-Col-1-Msg123456789012345
+			"test        text",
+			"test text",
+		},
+		{
+			"test&nbsp;&nbsp;&nbsp; text&nbsp;",
+			"test    text",
+		},
+	}
 
-Row-1-Col-1-Msg2
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
 
-Row-1-Col-2 Row-2-Col-1 Row-2-Col-2`,
+func TestNormalizeSpaces(t *testing.T) {
+	testCases := []struct {
+		input            string
+		output           string
+		normalizedOutput string
+	}{
+		{
+			"test&nbsp;&nbsp;&nbsp; text&nbsp;",
+			"test\u00a0\u00a0\u00a0 text",
+			"test text",
 		},
 		{
-			`<table>
-			   <tr><td>cell1-1</td><td>cell1-2</td></tr>
-			   <tr><td>cell2-1</td><td>cell2-2</td></tr>
-			</table>`,
-			// +---------+---------+
-			// | cell1-1 | cell1-2 |
-			// | cell2-1 | cell2-2 |
-			// +---------+---------+
-			"+---------+---------+\n| cell1-1 | cell1-2 |\n| cell2-1 | cell2-2 |\n+---------+---------+",
-			"cell1-1 cell1-2 cell2-1 cell2-2",
+			"zero\u200bwidth\ufeff join\u200d\u2060er",
+			"zero\u200bwidth\ufeff join\u200d\u2060er",
+			"zerowidth joiner",
 		},
 		{
-			`<table>
-				<thead>
-					<tr><th>Header 1</th><th>Header 2</th></tr>
-				</thead>
-				<tfoot>
-					<tr><td>Footer 1</td><td>Footer 2</td></tr>
-				</tfoot>
-				<tbody>
-					<tr><td>Row 1 Col 1</td><td>Row 1 Col 2</td></tr>
-					<tr><td>Row 2 Col 1</td><td>Row 2 Col 2</td></tr>
-				</tbody>
-			</table>`,
-			`+-------------+-------------+
-|  HEADER 1   |  HEADER 2   |
-+-------------+-------------+
+			"<p>a\u00a0\u200b b</p>",
+			"a\u00a0\u200b b",
+			"a b",
+		},
+		{
+			"<pre>a\u00a0\u00a0b\u200b</pre>",
+			"a\u00a0\u00a0b\u200b",
+			"a  b",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.normalizedOutput, Options{NormalizeSpaces: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTrailingNewline(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"",
+			"",
+		},
+		{
+			"Test text",
+			"Test text\n",
+		},
+		{
+			"<p>Test text</p>\n\n<br><br>",
+			"Test text\n",
+		},
+		{
+			`<table><tr><td>cell</td></tr></table>`,
+			"+------+\n| cell |\n+------+\n",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{TrailingNewline: true, PrettyTables: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestPreserveWhitespace(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<div>\n  /\\\n /  \\\n/____\\\n</div>",
+			"  /\\\n /  \\\n/____\\",
+		},
+		{
+			"<div>Name    Price\nGolang  $10\nRust    $12</div>",
+			"Name    Price\nGolang  $10\nRust    $12",
+		},
+		{
+			"<p>Hello <b>world</b>, again</p>",
+			"Hello *world*, again",
+		},
+		{
+			"<p>a</p>\n\n<p>  b  c</p>",
+			"a\n\n  b  c",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{PreserveWhitespace: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestParagraphsAndBreaks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"Test text",
+			"Test text",
+		},
+		{
+			"Test text<br>",
+			"Test text",
+		},
+		{
+			"Test text<br>Test",
+			"Test text\nTest",
+		},
+		{
+			"<p>Test text</p>",
+			"Test text",
+		},
+		{
+			"<p>Test text</p><p>Test text</p>",
+			"Test text\n\nTest text",
+		},
+		{
+			"\n<p>Test text</p>\n\n\n\t<p>Test text</p>\n",
+			"Test text\n\nTest text",
+		},
+		{
+			"\n<p>Test text<br/>Test text</p>\n",
+			"Test text\nTest text",
+		},
+		{
+			"\n<p>Test text<br> \tTest text<br></p>\n",
+			"Test text\nTest text",
+		},
+		{
+			"<p><b>Test</b><br><i>text</i></p><p>Test text</p>",
+			"*Test*\n_text_\n\nTest text",
+		},
+		{
+			"<p>Test text<br></p><p>Test text</p>",
+			"Test text\n\nTest text",
+		},
+		{
+			"<p>Test text<span><br></span>Test text</p>",
+			"Test text\nTest text",
+		},
+		{
+			"Test text<br><BR />Test text",
+			"Test text\n\nTest text",
+		},
+		{
+			"<pre>test1\ntest 2\n\ntest  3</pre>",
+			"test1\ntest 2\n\ntest  3",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDropEmptyBlocks(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<blockquote><p>One</p><p></p><p> </p><p>Two</p></blockquote>",
+			"> \n> \n> \n> One\n> \n> \n> \n> Two\n> \n>",
+			Options{DropEmptyBlocks: true},
+		},
+		{
+			"One<p></p>Two<p><span> </span></p>Three",
+			"One\nTwo\nThree",
+			Options{DropEmptyBlocks: true},
+		},
+		{
+			"One<p></p>Two<p><span> </span></p>Three",
+			"One\n\nTwo\n\nThree",
+			Options{},
+		},
+		{
+			"<p>One</p><p></p><section><p></p></section><p>Two</p>",
+			"One\n\n\nTwo",
+			Options{DropEmptyBlocks: true, MaxConsecutiveNewlines: 3},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestMaxConsecutiveNewlines(t *testing.T) {
+	testCases := []struct {
+		input       string
+		maxNewlines int
+		output      string
+	}{
+		{
+			"Test<br><br><br><br>Test",
+			0,
+			"Test\n\nTest",
+		},
+		{
+			"Test<br><br><br><br>Test",
+			3,
+			"Test\n\n\nTest",
+		},
+		{
+			"Test<br><br>Test",
+			3,
+			"Test\n\nTest",
+		},
+		{
+			"<p>Test</p><p>Test</p>",
+			1,
+			"Test\nTest",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{MaxConsecutiveNewlines: testCase.maxNewlines}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTables(t *testing.T) {
+	testCases := []struct {
+		input           string
+		tabularOutput   string
+		plaintextOutput string
+	}{
+		{
+			"<table><tr><td></td><td></td></tr></table>",
+			// Empty table
+			// +--+--+
+			// |  |  |
+			// +--+--+
+			"+--+--+\n|  |  |\n+--+--+",
+			"",
+		},
+		{
+			"<table><tr><td>cell1</td><td>cell2</td></tr></table>",
+			// +-------+-------+
+			// | cell1 | cell2 |
+			// +-------+-------+
+			"+-------+-------+\n| cell1 | cell2 |\n+-------+-------+",
+			"cell1\tcell2",
+		},
+		{
+			"<table><tr><td>row1</td></tr><tr><td>row2</td></tr></table>",
+			// +------+
+			// | row1 |
+			// | row2 |
+			// +------+
+			"+------+\n| row1 |\n| row2 |\n+------+",
+			"row1\nrow2",
+		},
+		{
+			`<table>
+				<tbody>
+					<tr><td><p>Row-1-Col-1-Msg123456789012345</p><p>Row-1-Col-1-Msg2</p></td><td>Row-1-Col-2</td></tr>
+					<tr><td>Row-2-Col-1</td><td>Row-2-Col-2</td></tr>
+				</tbody>
+			</table>`,
+			// +--------------------------------+-------------+
+			// | Row-1-Col-1-Msg123456789012345 | Row-1-Col-2 |
+			// | Row-1-Col-1-Msg2               |             |
+			// | Row-2-Col-1                    | Row-2-Col-2 |
+			// +--------------------------------+-------------+
+			`+--------------------------------+-------------+
+| Row-1-Col-1-Msg123456789012345 | Row-1-Col-2 |
+| Row-1-Col-1-Msg2               |             |
+| Row-2-Col-1                    | Row-2-Col-2 |
++--------------------------------+-------------+`,
+			"Row-1-Col-1-Msg123456789012345 Row-1-Col-1-Msg2\tRow-1-Col-2\nRow-2-Col-1\tRow-2-Col-2",
+		},
+		{
+			`<table>
+			   <tr><td>cell1-1</td><td>cell1-2</td></tr>
+			   <tr><td>cell2-1</td><td>cell2-2</td></tr>
+			</table>`,
+			// +---------+---------+
+			// | cell1-1 | cell1-2 |
+			// | cell2-1 | cell2-2 |
+			// +---------+---------+
+			"+---------+---------+\n| cell1-1 | cell1-2 |\n| cell2-1 | cell2-2 |\n+---------+---------+",
+			"cell1-1\tcell1-2\ncell2-1\tcell2-2",
+		},
+		{
+			`<table>
+				<thead>
+					<tr><th>Header 1</th><th>Header 2</th></tr>
+				</thead>
+				<tfoot>
+					<tr><td>Footer 1</td><td>Footer 2</td></tr>
+				</tfoot>
+				<tbody>
+					<tr><td>Row 1 Col 1</td><td>Row 1 Col 2</td></tr>
+					<tr><td>Row 2 Col 1</td><td>Row 2 Col 2</td></tr>
+				</tbody>
+			</table>`,
+			`+-------------+-------------+
+|  HEADER 1   |  HEADER 2   |
++-------------+-------------+
 | Row 1 Col 1 | Row 1 Col 2 |
 | Row 2 Col 1 | Row 2 Col 2 |
 +-------------+-------------+
 |  FOOTER 1   |  FOOTER 2   |
 +-------------+-------------+`,
-			"Header 1 Header 2 Footer 1 Footer 2 Row 1 Col 1 Row 1 Col 2 Row 2 Col 1 Row 2 Col 2",
+			"Header 1\tHeader 2\nFooter 1\tFooter 2\nRow 1 Col 1\tRow 1 Col 2\nRow 2 Col 1\tRow 2 Col 2",
+		},
+		// Footer rows but the last one are rendered at the end of the body.
+		{
+			`<table>
+				<thead><tr><th>Item</th><th>Price</th></tr></thead>
+				<tbody><tr><td>Book</td><td>$10</td></tr></tbody>
+				<tfoot>
+					<tr><td>Tax</td><td>$2</td></tr>
+					<tr><td>Total</td><td>$12</td></tr>
+				</tfoot>
+			</table>`,
+			`+-------+-------+
+| ITEM  | PRICE |
++-------+-------+
+| Book  | $10   |
+| Tax   | $2    |
++-------+-------+
+| TOTAL |  $12  |
++-------+-------+`,
+			"Item\tPrice\nBook\t$10\nTax\t$2\nTotal\t$12",
 		},
 		// Two tables in same HTML (goal is to test that context is
 		// reinitialized correctly).
@@ -289,9 +887,8 @@ Row-1-Col-2 Row-2-Col-1 Row-2-Col-2`,
 +---------------------+---------------------+
 |  TABLE 2 FOOTER 1   |  TABLE 2 FOOTER 2   |
 +---------------------+---------------------+`,
-			`Table 1 Header 1 Table 1 Header 2 Table 1 Footer 1 Table 1 Footer 2 Table 1 Row 1 Col 1 Table 1 Row 1 Col 2 Table 1 Row 2 Col 1 Table 1 Row 2 Col 2
-
-Table 2 Header 1 Table 2 Header 2 Table 2 Footer 1 Table 2 Footer 2 Table 2 Row 1 Col 1 Table 2 Row 1 Col 2 Table 2 Row 2 Col 1 Table 2 Row 2 Col 2`,
+			"Table 1 Header 1\tTable 1 Header 2\nTable 1 Footer 1\tTable 1 Footer 2\nTable 1 Row 1 Col 1\tTable 1 Row 1 Col 2\nTable 1 Row 2 Col 1\tTable 1 Row 2 Col 2\n\n" +
+				"Table 2 Header 1\tTable 2 Header 2\nTable 2 Footer 1\tTable 2 Footer 2\nTable 2 Row 1 Col 1\tTable 2 Row 1 Col 2\nTable 2 Row 2 Col 1\tTable 2 Row 2 Col 2",
 		},
 		{
 			"_<table><tr><td>cell</td></tr></table>_",
@@ -327,12 +924,110 @@ Table 2 Header 1 Table 2 Header 2 Table 2 Footer 1 Table 2 Footer 2 Table 2 Row
 |        | beautiful e-mails using        |        |
 |        | Golang.                        |        |
 +--------+--------------------------------+--------+`,
-			"Item Description Price Golang Open source programming language that makes it easy to build simple, reliable, and efficient software $10.99 Hermes Programmatically create beautiful e-mails using Golang. $1.99",
+			"Item\tDescription\tPrice\nGolang\tOpen source programming language that makes it easy to build simple, reliable, and efficient software\t$10.99\nHermes\tProgrammatically create beautiful e-mails using Golang.\t$1.99",
 		},
-	}
-
-	for _, testCase := range testCases {
-		options := Options{
+		{
+			`<table>
+				<thead>
+					<tr><td>Header 1</td><td>Header 2</td></tr>
+				</thead>
+				<tbody>
+					<tr><td>Row 1 Col 1</td><td>Row 1 Col 2</td></tr>
+				</tbody>
+			</table>`,
+			`+-------------+-------------+
+|  HEADER 1   |  HEADER 2   |
++-------------+-------------+
+| Row 1 Col 1 | Row 1 Col 2 |
++-------------+-------------+`,
+			"Header 1\tHeader 2\nRow 1 Col 1\tRow 1 Col 2",
+		},
+		{
+			`<table>
+				<thead>
+					<tr><td>Header 1</td><td>Header 2</td></tr>
+				</thead>
+				<tbody>
+					<tr><th>Row 1 Col 1</th><th>Row 1 Col 2</th></tr>
+				</tbody>
+			</table>`,
+			`+-------------+-------------+
+|  HEADER 1   |  HEADER 2   |
++-------------+-------------+
+| Row 1 Col 1 | Row 1 Col 2 |
++-------------+-------------+`,
+			"Header 1\tHeader 2\nRow 1 Col 1\tRow 1 Col 2",
+		},
+		{
+			`<table>
+				<tr><th colspan="2">Name</th><th>Price</th></tr>
+				<tr><td>Golang</td><td>Book</td><td>$10</td></tr>
+				<tr><td colspan="2">Total</td><td>$10</td></tr>
+				<tr><td>a</td><td colspan="0">b</td><td>c</td></tr>
+			</table>`,
+			`+--------+------+-------+
+|  NAME  |      | PRICE |
++--------+------+-------+
+| Golang | Book | $10   |
+| Total  |      | $10   |
+| a      | b    | c     |
++--------+------+-------+`,
+			"Name\tPrice\nGolang\tBook\t$10\nTotal\t$10\na\tb\tc",
+		},
+		{
+			`<table>
+				<tr><td rowspan="2">cell1</td><td>cell1-2</td></tr>
+				<tr><td>cell2-2</td></tr>
+			</table>`,
+			"+-------+---------+\n| cell1 | cell1-2 |\n|       | cell2-2 |\n+-------+---------+",
+			"cell1\tcell1-2\ncell2-2",
+		},
+		{
+			`<table>
+				<tr><td>cell1-1</td><td rowspan="2">cell2</td></tr>
+				<tr><td>cell2-1</td></tr>
+				<tr><td>cell3-1</td><td>cell3-2</td></tr>
+			</table>`,
+			"+---------+---------+\n| cell1-1 | cell2   |\n| cell2-1 |         |\n| cell3-1 | cell3-2 |\n+---------+---------+",
+			"cell1-1\tcell2\ncell2-1\ncell3-1\tcell3-2",
+		},
+		{
+			`<table>
+				<tr><th rowspan="2">A</th><th>B</th></tr>
+				<tr><td>c</td></tr>
+				<tr><td>d</td><td>e</td></tr>
+			</table>`,
+			"+---+---+\n| A | B |\n+---+---+\n|   | c |\n| d | e |\n+---+---+",
+			"A\tB\nc\nd\te",
+		},
+		// Short rows are padded to the width of the table.
+		{
+			`<table>
+				<tr><td>a</td><td>b</td></tr>
+				<tr><td>c</td></tr>
+				<tfoot><tr><td>x</td></tr></tfoot>
+			</table>`,
+			"+---+---+\n| a | b |\n| c |   |\n+---+---+\n| X |    \n+---+---+",
+			"a\tb\nc\nx",
+		},
+		{
+			`<table>
+				<caption>Sales</caption>
+				<tr><th>Month</th><th>Total</th></tr>
+				<tr><td>January</td><td>$100</td></tr>
+			</table>`,
+			`Sales
++---------+-------+
+|  MONTH  | TOTAL |
++---------+-------+
+| January | $100  |
++---------+-------+`,
+			"Sales\n\nMonth\tTotal\nJanuary\t$100",
+		},
+	}
+
+	for _, testCase := range testCases {
+		options := Options{
 			PrettyTables:        true,
 			PrettyTablesOptions: NewPrettyTablesOptions(),
 		}
@@ -352,6 +1047,452 @@ Table 2 Header 1 Table 2 Header 2 Table 2 Footer 1 Table 2 Footer 2 Table 2 Row
 	}
 }
 
+func TestPlainTableSeparator(t *testing.T) {
+	input := `<p>Prices:</p>
+		<table>
+			<tr><th>Item</th><th>Price</th></tr>
+			<tr><td>Golang <b>book</b></td><td>$10</td></tr>
+			<tr><td></td><td>$5</td></tr>
+		</table>`
+	testCases := []struct {
+		separator string
+		output    string
+	}{
+		{
+			"",
+			"Prices:\n\nItem\tPrice\nGolang *book*\t$10\n\t$5",
+		},
+		{
+			" | ",
+			"Prices:\n\nItem | Price\nGolang *book* | $10\n | $5",
+		},
+		{
+			";",
+			"Prices:\n\nItem;Price\nGolang *book*;$10\n;$5",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(input, testCase.output, Options{PlainTableSeparator: testCase.separator}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTableColumnAlignment(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<table>
+				<colgroup><col><col align="right"></colgroup>
+				<tr><th>Item</th><th>Price</th></tr>
+				<tr><td>Golang book</td><td>$10</td></tr>
+				<tr><td>Pen</td><td>$1234.50</td></tr>
+			</table>`,
+			`+-------------+----------+
+|    ITEM     |  PRICE   |
++-------------+----------+
+| Golang book |      $10 |
+| Pen         | $1234.50 |
++-------------+----------+`,
+		},
+		{
+			`<table>
+				<colgroup align="center" span="2"></colgroup>
+				<tr><td>a</td><td>bbbbbb</td></tr>
+				<tr><td>cccccc</td><td>d</td></tr>
+			</table>`,
+			`+--------+--------+
+|   a    | bbbbbb |
+| cccccc |   d    |
++--------+--------+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{PrettyTables: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestMaxTableWidth(t *testing.T) {
+	input := `<table>
+		<tr><th>Name</th><th>Description</th></tr>
+		<tr><td>html2text</td><td>Converts HTML documents into plain text suitable for terminals and emails</td></tr>
+		<tr><td>tablewriter</td><td>Renders ASCII tables</td></tr>
+	</table>`
+	testCases := []struct {
+		maxWidth int
+		output   string
+	}{
+		{
+			40,
+			`+-------------+----------------------+
+|    NAME     |     DESCRIPTION      |
++-------------+----------------------+
+| html2text   | Converts HTML        |
+|             | documents into plain |
+|             | text suitable for    |
+|             | terminals and emails |
+| tablewriter | Renders ASCII tables |
++-------------+----------------------+`,
+		},
+		{
+			30,
+			`+-------------+--------------+
+|    NAME     | DESCRIPTION  |
++-------------+--------------+
+| html2text   | Converts     |
+|             | HTML         |
+|             | documents    |
+|             | into         |
+|             | plain text   |
+|             | suitable for |
+|             | terminals    |
+|             | and emails   |
+| tablewriter | Renders      |
+|             | ASCII tables |
++-------------+--------------+`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		options := NewPrettyTablesOptions()
+		options.MaxTableWidth = testCase.maxWidth
+		if msg, err := wantString(input, testCase.output, Options{PrettyTables: true, PrettyTablesOptions: options}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	// Words wider than the room left are kept whole.
+	options := NewPrettyTablesOptions()
+	options.MaxTableWidth = 10
+	input = `<table><tr><td>abcdefghij</td><td>klmnopqrst</td></tr></table>`
+	output := `+------------+------------+
+| abcdefghij | klmnopqrst |
++------------+------------+`
+	if msg, err := wantString(input, output, Options{PrettyTables: true, PrettyTablesOptions: options}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestPreserveCellNewlines(t *testing.T) {
+	input := `<table>
+		<tr><th>Name</th><th>Address</th></tr>
+		<tr><td>Jane</td><td>Apt 4<br>12 Main Street<br>Springfield</td></tr>
+		<tr><td>Bob</td><td><p>First paragraph which is quite long indeed</p><p>Second</p></td></tr>
+	</table>`
+	output := `+------+--------------------------------+
+| NAME |            ADDRESS             |
++------+--------------------------------+
+| Jane | Apt 4                          |
+|      | 12 Main Street                 |
+|      | Springfield                    |
+| Bob  | First paragraph which is quite |
+|      | long indeed                    |
+|      | Second                         |
++------+--------------------------------+`
+
+	for _, reflow := range []bool{true, false} {
+		options := NewPrettyTablesOptions()
+		options.ReflowDuringAutoWrap = reflow
+		options.PreserveCellNewlines = true
+		if msg, err := wantString(input, output, Options{PrettyTables: true, PrettyTablesOptions: options}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTableCellsOutsideRows(t *testing.T) {
+	element := func(a atom.Atom, children ...*html.Node) *html.Node {
+		node := &html.Node{Type: html.ElementNode, Data: a.String(), DataAtom: a}
+		for _, child := range children {
+			node.AppendChild(child)
+		}
+		return node
+	}
+	cell := func(text string) *html.Node {
+		return element(atom.Td, &html.Node{Type: html.TextNode, Data: text})
+	}
+	// The parser wraps cells in rows, so the malformed table is built by hand.
+	table := element(atom.Table, cell("a"), cell("b"), element(atom.Tr, cell("c"), cell("d")), cell("e"), cell("f"))
+
+	testCases := []struct {
+		options Options
+		output  string
+	}{
+		{
+			Options{PrettyTables: true},
+			`+---+---+
+| a | b |
+| c | d |
+| e | f |
++---+---+`,
+		},
+		{
+			Options{},
+			"a\tb\nc\td\ne\tf",
+		},
+	}
+
+	for _, testCase := range testCases {
+		text, err := FromHTMLNode(table, testCase.options)
+		if err != nil {
+			t.Error(err)
+		} else if text != testCase.output {
+			t.Errorf("expected %q, got %q", testCase.output, text)
+		}
+	}
+}
+
+func TestTablesFromHTMLNode(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`
+		<p>Intro</p>
+		<table>
+			<thead><tr><th>Name</th><th>Price</th></tr></thead>
+			<tfoot><tr><td>Total</td><td>$22</td></tr></tfoot>
+			<tbody>
+				<tr><td><b>Golang</b></td><td>$10</td></tr>
+				<tr><td>Rust</td><td>$12</td></tr>
+			</tbody>
+		</table>
+		<table><tr><td>Nested: <table><tr><td>inner</td></tr></table></td></tr></table>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := TablesFromHTMLNode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Table{
+		{
+			Header: []string{"Name", "Price"},
+			Body:   [][]string{{"*Golang*", "$10"}, {"Rust", "$12"}},
+			Footer: []string{"Total", "$22"},
+		},
+		{
+			Header: []string{},
+			Body:   [][]string{{"Nested:\n+-------+\n| inner |\n+-------+"}},
+			Footer: []string{},
+		},
+		{
+			Header: []string{},
+			Body:   [][]string{{"inner"}},
+			Footer: []string{},
+		},
+	}
+	if got, expected := fmt.Sprintf("%q", tables), fmt.Sprintf("%q", want); got != expected {
+		t.Fatalf("unexpected tables\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestStructuredFromHTMLNode(t *testing.T) {
+	doc, err := html.Parse(strings.NewReader(`
+		<html><head><title>Title</title></head><body>
+		<h1>Main <i>title</i></h1>
+		<div><p>Intro with a <a href="http://example.com/">link</a></p></div>
+		<h3>Section</h3>
+		<ul><li>One</li><li>Two<ol><li>Nested</li></ol></li></ul>
+		Stray text
+		<table><tr><td>cell1</td><td>cell2</td></tr></table>
+		<blockquote><p>Quoted</p><blockquote>Deeper</blockquote></blockquote>
+		<p hidden>Hidden</p><p></p>
+		<section>Loose <b>inline</b> text<br>on two lines<p>Then a paragraph</p></section>
+		<pre>  indented
+code</pre>
+		<address>Jane Doe</address>
+		<figure><img alt="Chart"><figcaption>Sales</figcaption></figure>
+		<details><summary>More</summary>Details</details>
+		<hr>
+		Last words
+		</body></html>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := StructuredFromHTMLNode(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Block{
+		{Type: BlockHeading, Level: 1, Text: "Main _title_"},
+		{Type: BlockParagraph, Text: "Intro with a link ( http://example.com/ )"},
+		{Type: BlockHeading, Level: 3, Text: "Section"},
+		{Type: BlockList, Text: "* One\n* Two\n  1. Nested"},
+		{Type: BlockParagraph, Text: "Stray text"},
+		{Type: BlockTable, Text: "cell1\tcell2"},
+		{Type: BlockBlockquote, Level: 1, Text: "Quoted\n\n> \n> Deeper", Children: []Block{
+			{Type: BlockParagraph, Text: "Quoted"},
+			{Type: BlockBlockquote, Level: 2, Text: "Deeper", Children: []Block{
+				{Type: BlockParagraph, Text: "Deeper"},
+			}},
+		}},
+		{Type: BlockParagraph, Text: "Loose *inline* text\non two lines"},
+		{Type: BlockParagraph, Text: "Then a paragraph"},
+		{Type: BlockPreformatted, Text: "  indented\ncode"},
+		{Type: BlockParagraph, Text: "Jane Doe"},
+		{Type: BlockParagraph, Text: "Chart\nFigure: Sales"},
+		{Type: BlockParagraph, Text: "\u25b8 More\n  Details"},
+		{Type: BlockParagraph, Text: "Last words"},
+	}
+	got, err := json.Marshal(blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := json.Marshal(want)
+	if string(got) != string(expected) {
+		t.Fatalf("unexpected blocks\ngot:      %s\nexpected: %s", got, expected)
+	}
+}
+
+func TestTemplate(t *testing.T) {
+	tmpl := template.Must(template.New("block").Parse(
+		`{{if eq .Type "heading"}}=== {{.Text}} ==={{else}}{{.Text}}{{end}}` + "\n"))
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<h1>Title</h1><p>Some <b>text</b>.</p><h2>Items</h2><ul><li>One</li><li>Two</li></ul>`,
+			"=== Title ===\nSome *text*.\n=== Items ===\n* One\n* Two\n",
+			Options{Template: tmpl},
+		},
+		{
+			`<p>See <a href="http://example.com/">the site</a>.</p>`,
+			"See the site [1].\n\n[1] http://example.com/",
+			Options{Template: tmpl, LinkFootnotes: true},
+		},
+		{
+			`<div>Outside of blocks</div>`,
+			"Outside of blocks\n",
+			Options{Template: tmpl},
+		},
+		{
+			`<body>Hello <b>world</b></body>`,
+			"Hello *world*\n",
+			Options{Template: tmpl},
+		},
+		{
+			"<div>Intro</div><pre>  code\n  more</pre>",
+			"Intro\n  code\n  more\n",
+			Options{Template: tmpl},
+		},
+		{
+			`<html><head><title>Page</title><meta name="description" content="About it"><base href="http://x.com/a/"></head>` +
+				`<body><a href="b">Link</a></body></html>`,
+			"=== Page ===\nAbout it\nLink ( http://x.com/a/b )\n",
+			Options{Template: tmpl, IncludeTitle: true, IncludeMetaDescription: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	// Quoted content is counted once, though rendered for both the quote and
+	// its children.
+	input := `<blockquote><p><a href="http://a.com/">a</a></p><blockquote><a href="http://b.com/">b</a></blockquote></blockquote>`
+	if _, stats, err := FromStringWithStats(input, Options{Template: tmpl}); err != nil {
+		t.Error(err)
+	} else if stats.LinkCount != 2 {
+		t.Errorf("expected 2 links, got %d", stats.LinkCount)
+	}
+
+	failing := template.Must(template.New("block").Parse(`{{.Missing}}`))
+	if _, err := FromString(`<p>Text</p>`, Options{Template: failing}); err == nil {
+		t.Error("expected an error executing the template")
+	}
+}
+
+// Table cells may be handled without their table, which spanned cells
+// mustn't trip on.
+func TestTableCellsWithoutTable(t *testing.T) {
+	input := `<table>
+		<tr><th rowspan="2">A</th><th>B</th></tr>
+		<tr><td rowspan="2">a</td><td>b</td></tr>
+		<tr><td>c</td></tr>
+	</table>`
+
+	table := &html.Node{Type: html.ElementNode, Data: "table", DataAtom: atom.Table}
+	nodes, err := html.ParseFragment(strings.NewReader(`<tr><td rowspan="2">a</td><td>b</td></tr><tr><td>c</td></tr>`), table)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, node := range nodes {
+		if _, err := FromHTMLNode(node, Options{PrettyTables: true}); err != nil {
+			t.Errorf("fragment: %v", err)
+		}
+	}
+
+	handlers := map[atom.Atom]NodeHandler{
+		atom.Table: func(node *html.Node, content string) (string, error) {
+			return content, nil
+		},
+	}
+	if _, err := FromString(input, Options{PrettyTables: true, NodeHandlers: handlers}); err != nil {
+		t.Errorf("table handler: %v", err)
+	}
+
+	for _, allowed := range [][]atom.Atom{{atom.Td, atom.Tr}, {atom.Th}} {
+		if _, err := FromString(input, Options{PrettyTables: true, AllowedAtoms: allowed}); err != nil {
+			t.Errorf("allowed atoms %v: %v", allowed, err)
+		}
+	}
+}
+
+func TestTableCellLineBreaks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<table><tr><td>Line 1<br>Line 2</td><td>Cell</td></tr></table>",
+			"+--------+------+\n| Line 1 | Cell |\n| Line 2 |      |\n+--------+------+",
+		},
+		{
+			"<table><tr><td>Line 1<br><br>Line 3</td><td>Cell</td></tr></table>",
+			"+--------+------+\n| Line 1 | Cell |\n|        |      |\n| Line 3 |      |\n+--------+------+",
+		},
+		{
+			"<table><tr><td><span>Line 1<br>Line 2</span></td><td>Cell</td></tr></table>",
+			"+--------+------+\n| Line 1 | Cell |\n| Line 2 |      |\n+--------+------+",
+		},
+	}
+
+	prettyTablesOptions := NewPrettyTablesOptions()
+	prettyTablesOptions.AutoWrapText = false
+	options := Options{
+		PrettyTables:        true,
+		PrettyTablesOptions: prettyTablesOptions,
+	}
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
 func TestStrippingLists(t *testing.T) {
 	testCases := []struct {
 		input  string
@@ -370,8 +1511,2187 @@ func TestStrippingLists(t *testing.T) {
 			"* item 1\n* item 2\n_",
 		},
 		{
-			"<li>item 1</li> \t\n <li>item 2</li> <li> item 3</li>\n_",
-			"* item 1\n* item 2\n* item 3\n_",
+			"<li>item 1</li> \t\n <li>item 2</li> <li> item 3</li>\n_",
+			"* item 1\n* item 2\n* item 3\n_",
+		},
+		{
+			"<ul><li>item 1<ul><li>item 1.1<ul><li>item 1.1.1</li></ul></li></ul></li><li>item 2</li></ul>_",
+			"* item 1\n  * item 1.1\n    * item 1.1.1\n* item 2\n\n_",
+		},
+		{
+			"<ul><li><b>item 1</b><ul><li><a href='http://example.com/'>item 1.1</a></li></ul></li></ul>",
+			"* *item 1*\n  * item 1.1 ( http://example.com/ )",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestListBullet(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<ul><li>item 1</li><li>item 2</li></ul>",
+			"- item 1\n- item 2",
+		},
+		{
+			"<ul><li>item 1<ul><li>item 1.1</li></ul></li></ul>",
+			"- item 1\n  - item 1.1",
+		},
+		{
+			"<ol><li>item 1</li></ol>",
+			"1. item 1",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{ListBullet: "-"}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestOrderedLists(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<ol></ol>",
+			"",
+		},
+		{
+			"<ol><li>item 1</li><li>item 2</li></ol>_",
+			"1. item 1\n2. item 2\n\n_",
+		},
+		{
+			`<ol start="5"><li>item 5</li><li>item 6</li></ol>`,
+			"5. item 5\n6. item 6",
+		},
+		{
+			`<ol reversed><li>item 3</li><li>item 2</li><li>item 1</li></ol>`,
+			"3. item 3\n2. item 2\n1. item 1",
+		},
+		{
+			`<ol reversed start="10"><li>item 10</li><li>item 9</li></ol>`,
+			"10. item 10\n9. item 9",
+		},
+		{
+			`<ol reversed><li>item 2<ol><li>item 2.1</li><li>item 2.2</li></ol></li><li>item 1</li></ol>`,
+			"2. item 2\n  1. item 2.1\n  2. item 2.2\n1. item 1",
+		},
+		{
+			"<ol><li>item 1</li></ol><ol><li>item 1</li></ol>",
+			"1. item 1\n\n1. item 1",
+		},
+		{
+			"<ol><li>item 1<ol><li>item 1.1</li><li>item 1.2</li></ol></li><li>item 2<ul><li>item 2.1</li></ul></li><li>item 3</li></ol>",
+			"1. item 1\n  1. item 1.1\n  2. item 1.2\n2. item 2\n  * item 2.1\n3. item 3",
+		},
+		{
+			"<ol><li>item 1</li><p>Note</p><li>item 2</li><!-- comment --><li>item 3</li></ol>",
+			"1. item 1\n\nNote\n\n2. item 2\n3. item 3",
+		},
+		{
+			"<ol><li>item 1</li><div><ol><li>item a</li><li>item b</li></ol></div><li>item 2</li></ol>",
+			"1. item 1\n  1. item a\n  2. item b\n\n2. item 2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDefinitionLists(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<dl></dl>",
+			"",
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition</dd></dl>",
+			"Term\n  Definition",
+		},
+		{
+			"<dl><dt>Term</dt><dd>Definition 1</dd><dd>Definition 2</dd></dl>",
+			"Term\n  Definition 1\n  Definition 2",
+		},
+		{
+			"Before<dl>\n\t<dt>Term 1</dt>\n\t<dd>Definition 1</dd>\n\t<dt>Term 2</dt>\n\t<dd>Definition <b>2</b></dd>\n</dl>After",
+			"Before\n\nTerm 1\n  Definition 1\nTerm 2\n  Definition *2*\n\nAfter",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLinks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a></a>`,
+			``,
+		},
+		{
+			`<a href=""></a>`,
+			``,
+		},
+		{
+			`<a href="http://example.com/"></a>`,
+			`( http://example.com/ )`,
+		},
+		{
+			`<a href="">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			`<a href="http://example.com/"><span class="a">Link</span></a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href='mailto:contact@example.org'>Contact Us</a>",
+			`Contact Us ( contact@example.org )`,
+		},
+		{
+			"<a href=\"http://example.com:80/~user?aaa=bb&amp;c=d,e,f#foo\">Link</a>",
+			`Link ( http://example.com:80/~user?aaa=bb&c=d,e,f#foo )`,
+		},
+		{
+			"<a title='title' href=\"http://example.com/\">Link</a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href=\"   http://example.com/ \"> Link </a>",
+			`Link ( http://example.com/ )`,
+		},
+		{
+			"<a href=\"http://example.com/a/\">Link A</a> <a href=\"http://example.com/b/\">Link B</a>",
+			`Link A ( http://example.com/a/ ) Link B ( http://example.com/b/ )`,
+		},
+		{
+			"<a href=\"%%LINK%%\">Link</a>",
+			`Link ( %%LINK%% )`,
+		},
+		{
+			"<a href=\"[LINK]\">Link</a>",
+			`Link ( [LINK] )`,
+		},
+		{
+			"<a href=\"{LINK}\">Link</a>",
+			`Link ( {LINK} )`,
+		},
+		{
+			"<a href=\"[[!unsubscribe]]\">Link</a>",
+			`Link ( [[!unsubscribe]] )`,
+		},
+		{
+			"<p>This is <a href=\"http://www.google.com\" >link1</a> and <a href=\"http://www.google.com\" >link2 </a> is next.</p>",
+			`This is link1 ( http://www.google.com ) and link2 ( http://www.google.com ) is next.`,
+		},
+		{
+			"<a href=\"http://www.google.com\" >http://www.google.com</a>",
+			`http://www.google.com`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLinkFootnotes(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a href="http://example.com/">Link</a>`,
+			"Link [1]\n\n[1] http://example.com/",
+		},
+		{
+			`<p>See <a href="http://example.com/a">A</a> or <a href="http://example.com/b">B</a> and <a href="http://example.com/a">A again</a>.</p>`,
+			"See A [1] or B [2] and A again [1].\n\n[1] http://example.com/a\n[2] http://example.com/b",
+		},
+		{
+			`<b><a href="http://example.com/">Bold</a></b> <a href="http://example.com/">http://example.com/</a>`,
+			"*Bold [1]* http://example.com/\n\n[1] http://example.com/",
+		},
+		{
+			`<a href="">Link</a>`,
+			"Link",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LinkFootnotes: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	options := Options{LinkFootnotes: true, PrettyTables: true}
+	input := `<table><tr><td><a href="http://example.com/">Cell</a></td></tr></table>`
+	if msg, err := wantString(input, "+----------+\n| Cell [1] |\n+----------+\n\n[1] http://example.com/", options); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestPreferLinkTitle(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a title="Example home page" href="http://example.com/">Link</a>`,
+			`Link ( Example home page )`,
+		},
+		{
+			`<a title="Tom &amp; Jerry" href="http://example.com/">Link</a>`,
+			`Link ( Tom & Jerry )`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			`<a title=" " href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{PreferLinkTitle: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestShowLinkRel(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a rel="nofollow" href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ ) [nofollow]`,
+		},
+		{
+			`<a rel=" sponsored  nofollow " href="http://example.com/">Ad</a> here`,
+			`Ad ( http://example.com/ ) [sponsored nofollow] here`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			`<a rel="nofollow">Link</a>`,
+			`Link`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{ShowLinkRel: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestMaxLinkLength(t *testing.T) {
+	longLink := "https://example.com/" + strings.Repeat("a", 170) + "?utm_source=z"
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<a href="` + longLink + `">Link</a>`,
+			"Link ( https://example.com...aaaaa?utm_source=z )",
+			Options{MaxLinkLength: 40},
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			"Link ( http://example.com/ )",
+			Options{MaxLinkLength: 40},
+		},
+		{
+			`<a href="` + longLink + `">Link</a>`,
+			"Link ( " + longLink + " )",
+			Options{},
+		},
+		{
+			`<a href="` + longLink + `">Link</a>`,
+			"Link [1]\n\n[1] " + longLink,
+			Options{MaxLinkLength: 40, LinkFootnotes: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestAutolinkBareURLs(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"Visit http://example.com/ today",
+			"Visit <http://example.com/> today",
+		},
+		{
+			"<p>See https://example.com/a?b=c&amp;d=e, or https://example.org/x.</p>",
+			"See <https://example.com/a?b=c&d=e>, or <https://example.org/x>.",
+		},
+		{
+			"(https://example.com/path)",
+			"(<https://example.com/path>)",
+		},
+		{
+			`<a href="http://example.com/">http://example.com/</a>`,
+			"http://example.com/",
+		},
+		{
+			`<a href="http://example.com/">Go to <b>http://example.com/</b></a>`,
+			"Go to *http://example.com/* ( http://example.com/ )",
+		},
+		{
+			"<pre>curl http://example.com/</pre>",
+			"curl http://example.com/",
+		},
+		{
+			"No links here",
+			"No links here",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{AutolinkBareURLs: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestHiddenElements(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<p hidden>Hidden</p><p>Shown</p>`,
+			"Shown",
+		},
+		{
+			`<div style="display:none;max-height:0">Preheader text</div><p>Hello <span style="Display: None !important">secret</span>world</p>`,
+			"Hello world",
+		},
+		{
+			`<p>Hello <span style="color: red; visibility: hidden">secret</span>world</p>`,
+			"Hello world",
+		},
+		{
+			`<p style="display: block">Shown</p>`,
+			"Shown",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestSkipSelectors(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<p>Content</p><div class="page footer">Unsubscribe <a href="http://example.com/">here</a></div>`,
+			"Content",
+		},
+		{
+			`<p>Content <span id="ad">Buy now</span>here</p>`,
+			"Content here",
+		},
+		{
+			`<p class="footers" id="ads">Kept</p>`,
+			"Kept",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{SkipSelectors: []string{".footer", "#ad"}}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBracketLinkText(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<a href="http://example.com/">Click <b>here</b></a>`,
+			`[Click *here*]( http://example.com/ )`,
+			Options{BracketLinkText: true},
+		},
+		{
+			`<p>See <a href="http://example.com/">http://example.com/</a> now</p>`,
+			`See [http://example.com/]( http://example.com/ ) now`,
+			Options{BracketLinkText: true},
+		},
+		{
+			`<a href="http://example.com/">Click here</a>`,
+			`[Click here][1]
+
+[1] http://example.com/`,
+			Options{BracketLinkText: true, LinkFootnotes: true},
+		},
+		{
+			`<p>See <a href="http://example.com/">Click here</a> now</p>`,
+			`See Click here now`,
+			Options{BracketLinkText: true, OmitLinks: true},
+		},
+		{
+			`<a href="">Click here</a>`,
+			`Click here`,
+			Options{BracketLinkText: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestAllowedAtoms(t *testing.T) {
+	input := `<html><head><title>Title</title><style>p {}</style></head><body>
+		<h1>Heading</h1>
+		<p>Some <b>bold</b> text with a <a href="http://example.com/">link</a></p>
+		<ul><li>Item</li></ul>
+		<script>alert(1)</script>
+	</body></html>`
+	testCases := []struct {
+		output  string
+		options Options
+	}{
+		{
+			"Heading\n\nSome bold text with a link ( http://example.com/ )\n\nItem",
+			Options{AllowedAtoms: []atom.Atom{atom.P, atom.A}},
+		},
+		{
+			"Some text with a link ( http://example.com/ )",
+			Options{AllowedAtoms: []atom.Atom{atom.P, atom.A}, SkipDisallowedAtoms: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestOmitLinkDomains(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a href="http://example.com/about">About</a>`,
+			`About`,
+		},
+		{
+			`<a href="https://www.Example.com:8080/blog">Blog</a>`,
+			`Blog`,
+		},
+		{
+			`<a href="http://example.org/">Other</a>`,
+			`Other ( http://example.org/ )`,
+		},
+		{
+			`<a href="http://notexample.com/">Other</a>`,
+			`Other ( http://notexample.com/ )`,
+		},
+		{
+			`<a href="/about">About</a>`,
+			`About ( /about )`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{OmitLinkDomains: []string{"example.com"}}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestContactLinks(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<a href='mailto:contact@example.org'>Contact Us</a>",
+			"Contact Us ( contact@example.org )",
+			Options{},
+		},
+		{
+			"<a href='mailto:contact@example.org'>Contact Us</a>",
+			"Contact Us ( mailto:contact@example.org )",
+			Options{KeepMailtoPrefix: true},
+		},
+		{
+			"<a href='tel:+15551234567'>Call Us</a>",
+			"Call Us ( tel:+15551234567 )",
+			Options{},
+		},
+		{
+			"<a href='tel:+15551234567'>Call Us</a>",
+			"Call Us ( +15551234567 )",
+			Options{StripTelPrefix: true},
+		},
+		{
+			"<a href='tel:+15551234567'>+15551234567</a>",
+			"+15551234567",
+			Options{StripTelPrefix: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<a href="page.html">Page</a>`,
+			"Page ( page.html )",
+			Options{},
+		},
+		{
+			`<a href="page.html">Page</a> <a href="/root.html">Root</a> <a href="http://example.org/">Other</a>`,
+			"Page ( http://example.com/docs/page.html ) Root ( http://example.com/root.html ) Other ( http://example.org/ )",
+			Options{BaseURL: "http://example.com/docs/index.html"},
+		},
+		{
+			`<a href="#usage">Usage</a> <a href="mailto:contact@example.org">Contact</a>`,
+			"Usage ( #usage ) Contact ( contact@example.org )",
+			Options{BaseURL: "http://example.com/docs/index.html"},
+		},
+		{
+			`<html><head><base href="http://example.net/blog/"></head><body><a href="post.html">Post</a></body></html>`,
+			"Post ( http://example.net/blog/post.html )",
+			Options{},
+		},
+		{
+			`<html><head><base href="/blog/"></head><body><a href="post.html">Post</a></body></html>`,
+			"Post ( http://example.com/blog/post.html )",
+			Options{BaseURL: "http://example.com/docs/index.html"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestLinkNormalizer(t *testing.T) {
+	stripTracking := func(link string) string {
+		u, err := url.Parse(strings.TrimSpace(link))
+		if err != nil {
+			return link
+		}
+		query := u.Query()
+		for key := range query {
+			if strings.HasPrefix(key, "utm_") {
+				query.Del(key)
+			}
+		}
+		u.RawQuery = query.Encode()
+		return u.String()
+	}
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a href="http://example.com/?utm_source=mail&amp;utm_medium=email">Link</a>`,
+			`Link ( http://example.com/ )`,
+		},
+		{
+			`<a href=" http://example.com/page?id=1&amp;utm_campaign=spring ">Link</a>`,
+			`Link ( http://example.com/page?id=1 )`,
+		},
+		{
+			`<a href="mailto:contact@example.org">Contact</a>`,
+			`Contact ( mailto:contact@example.org )`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LinkNormalizer: stripTracking}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestOmitFragmentLinks(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<ul><li><a href="#intro">Intro</a></li><li><a href="#usage">Usage</a></li></ul>`,
+			"* Intro ( #intro )\n* Usage ( #usage )",
+			Options{},
+		},
+		{
+			`<ul><li><a href="#intro">Intro</a></li><li><a href="#usage">Usage</a></li></ul>`,
+			"* Intro\n* Usage",
+			Options{OmitFragmentLinks: true},
+		},
+		{
+			`<a href="http://example.com/#usage">Usage</a>`,
+			"Usage ( http://example.com/#usage )",
+			Options{OmitFragmentLinks: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestOmitLinks(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<a></a>`,
+			``,
+		},
+		{
+			`<a href=""></a>`,
+			``,
+		},
+		{
+			`<a href="http://example.com/"></a>`,
+			``,
+		},
+		{
+			`<a href="">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/">Link</a>`,
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/"><span class="a">Link</span></a>`,
+			`Link`,
+		},
+		{
+			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
+			`Link`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			`Example`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{OmitLinks: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestImageAltTags(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<img />`,
+			``,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" />`,
+			``,
+		},
+		{
+			`<img alt="Example"/>`,
+			`Example`,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" alt="Example"/>`,
+			`Example`,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" title="Title"/>`,
+			`Title`,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" alt="Example" title="Title"/>`,
+			`Example`,
+		},
+		{
+			`<p>Before <img alt="Example"/> after</p>`,
+			`Before Example after`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'/></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'></a>`,
+			`Example ( http://example.com/ )`,
+		},
+		{
+			`<a href="http://example.com/">Go <img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
+			`Go Example ( http://example.com/ )`,
+		},
+		// Attribute values are decoded by the parser, exactly once.
+		{
+			`<img alt="Tom &amp; Jerry"/>`,
+			`Tom & Jerry`,
+		},
+		{
+			`<img title="Caf&#233; &eacute;clair"/>`,
+			`Café éclair`,
+		},
+		{
+			`<img alt="&amp;amp;"/>`,
+			`&amp;`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestFigures(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<figure><img src="chart.png" alt="Sales chart"><figcaption>Sales in 2023</figcaption></figure>`,
+			"Sales chart\nFigure: Sales in 2023",
+			Options{},
+		},
+		{
+			`Before<figure><figcaption>Sales in <b>2023</b></figcaption><img src="chart.png" alt="Sales chart"></figure>After`,
+			"Before\n\nSales chart\nFigure: Sales in *2023*\n\nAfter",
+			Options{},
+		},
+		{
+			`<figure><img src="chart.png" alt="Sales chart"><figcaption>Sales in 2023</figcaption></figure>`,
+			"Sales chart\nFig. 1 - Sales in 2023",
+			Options{FigurePrefix: "Fig. 1 - "},
+		},
+		{
+			`<figure><img src="chart.png" alt="Sales chart"><figcaption>Sales in 2023</figcaption></figure>`,
+			"Sales chart\nSales in 2023",
+			Options{TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDetails(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<details><summary>More</summary><p>Body</p></details>`,
+			"\u25b8 More\n  Body",
+			Options{},
+		},
+		{
+			`Before<details><p>Body</p><p>Second</p><summary>More</summary></details>After`,
+			"Before\n\n\u25b8 More\n  Body\n\n  Second\n\nAfter",
+			Options{},
+		},
+		{
+			`<details><summary>More</summary><p>Body</p></details>`,
+			"More\nBody",
+			Options{TextOnly: true},
+		},
+		{
+			`Before<details><summary>More</summary><p>Body</p><p>Second</p></details>After`,
+			"Before\n\n**More**\n> Body\n>\n> Second\n\nAfter",
+			Options{Markdown: true},
+		},
+		{
+			`<details><summary>More</summary><p>Body</p></details>`,
+			"More\nBody",
+			Options{Markdown: true, TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestIncludeImageSrc(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			`<img src="http://example.ru/hello.jpg" />`,
+			`( http://example.ru/hello.jpg )`,
+		},
+		{
+			`<img src="http://example.ru/hello.jpg" alt="Example"/>`,
+			`Example ( http://example.ru/hello.jpg )`,
+		},
+		{
+			`<img alt="Example"/>`,
+			`Example`,
+		},
+		{
+			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
+			`Example ( http://example.ru/hello.jpg ) ( http://example.com/ )`,
+		},
+		{
+			`<img src="logo.png" alt="Logo" width="200" height="50"/>`,
+			`Logo ( logo.png 200x50 )`,
+		},
+		{
+			`<img src="logo.png" alt="Logo" width="200"/>`,
+			`Logo ( logo.png )`,
+		},
+		{
+			`<pre>See <img src="logo.png" alt="Logo"/> here</pre>`,
+			`See Logo ( logo.png ) here`,
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{IncludeImageSrc: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestHeadings(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<h1>Test</h1>",
+			"****\nTest\n****",
+		},
+		{
+			"\t<h1>\nTest</h1> ",
+			"****\nTest\n****",
+		},
+		{
+			"\t<h1>\nTest line 1<br>Test 2</h1> ",
+			"***********\nTest line 1\nTest 2\n***********",
+		},
+		{
+			"<h1>Test</h1> <h1>Test</h1>",
+			"****\nTest\n****\n\n****\nTest\n****",
+		},
+		{
+			"<h2>Test</h2>",
+			"----\nTest\n----",
+		},
+		{
+			"<h1><a href='http://example.com/'>Test</a></h1>",
+			"****************************\nTest ( http://example.com/ )\n****************************",
+		},
+		{
+			"<h3> <span class='a'>Test </span></h3>",
+			"Test\n----",
+		},
+		{
+			"<h4>Test</h4>",
+			"Test\n~~~~",
+		},
+		{
+			"<h5>Test</h5>",
+			"Test\n^^^^",
+		},
+		{
+			"<h6>Test</h6>",
+			"Test\n....",
+		},
+		{
+			"<h4>Test</h4><p>Text</p><h5>Test</h5>",
+			"Test\n~~~~\n\nText\n\nTest\n^^^^",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString("<h4>Test</h4><h6>Test</h6>", "Test.\n\nTest.", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+
+	input := "<h2>Ärger <b>im</b> Café</h2><p>Body text</p>"
+	if msg, err := wantString(input, "ÄRGER IM CAFÉ.\n\nBody text", Options{TextOnly: true, UppercaseHeadings: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	if msg, err := wantString(input, "---------------\nÄrger *im* Café\n---------------\n\nBody text", Options{UppercaseHeadings: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+
+	// Casing follows the language of the nearest lang attribute.
+	input = `<div lang="tr"><h2>istanbul</h2><h2 lang="en">istanbul</h2></div><h2>istanbul</h2>`
+	if msg, err := wantString(input, "İSTANBUL.\n\nISTANBUL.\n\nISTANBUL.", Options{TextOnly: true, UppercaseHeadings: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestSectioningElements(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<section>First</section><section>Second</section><article>Third</article><aside>Fourth</aside>`,
+			"First\n\nSecond\n\nThird\n\nFourth",
+			Options{},
+		},
+		{
+			`<nav><a href="/">Home</a></nav><article><section>Text</section></article>`,
+			"Home ( / )\n\nText",
+			Options{},
+		},
+		{
+			`<nav><a href="/">Home</a></nav><article><section>Text</section></article>`,
+			"Text",
+			Options{SkipNav: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestNoscript(t *testing.T) {
+	input := `<p>Hello</p><noscript>Please enable JavaScript</noscript><p>Bye</p>`
+
+	if msg, err := wantString(input, "Hello\n\nPlease enable JavaScript\n\nBye"); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	if msg, err := wantString(input, "Hello\n\nBye", Options{SkipNoscript: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestIncludeTitle(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<html><head><title>Page title</title><style>p {}</style></head><body><p>Content</p></body></html>`,
+			"Content",
+			Options{},
+		},
+		{
+			`<html><head><title>Page title</title><style>p {}</style></head><body><p>Content</p></body></html>`,
+			"**********\nPage title\n**********\n\nContent",
+			Options{IncludeTitle: true},
+		},
+		{
+			`<html><head><title>Page title</title></head><body><p>Content</p></body></html>`,
+			"Page title.\n\nContent",
+			Options{IncludeTitle: true, TextOnly: true},
+		},
+		{
+			`<html><head><title> </title></head><body><p>Content</p></body></html>`,
+			"Content",
+			Options{IncludeTitle: true},
+		},
+		{
+			`<html><head><meta charset="utf-8"><meta name="Description" content=" A page
+				about things. "><title>Page title</title></head><body><p>Content</p></body></html>`,
+			"A page about things.\n\nContent",
+			Options{IncludeMetaDescription: true},
+		},
+		{
+			`<html><head><meta name="description" content="A page about things."><title>Page title</title></head><body><p>Content</p></body></html>`,
+			"**********\nPage title\n**********\n\nA page about things.\n\nContent",
+			Options{IncludeTitle: true, IncludeMetaDescription: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestHeadingUnderlines(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<h1>Test</h1>",
+			"====\nTest\n====",
+		},
+		{
+			"<h2>Test line 1<br>Test 2</h2>",
+			"~~~~~~~~~~~\nTest line 1\nTest 2\n~~~~~~~~~~~",
+		},
+		{
+			"<h3>Test</h3>",
+			"Test\n----",
+		},
+		{
+			"<h4>Test</h4>",
+			"Test\n-=-=",
+		},
+		{
+			"<h4>Tests</h4>",
+			"Tests\n-=-=-",
+		},
+	}
+
+	options := Options{HeadingUnderlines: [6]string{"=", "~", "", "-="}}
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestBold(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<b>Test</b>",
+			"*Test*",
+		},
+		{
+			"\t<b>Test</b> ",
+			"*Test*",
+		},
+		{
+			"\t<b>Test line 1<br>Test 2</b> ",
+			"*Test line 1\nTest 2*",
+		},
+		{
+			"<b>Test</b> <b>Test</b>",
+			"*Test* *Test*",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	textOnlyTestCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<p>Click <b>here</b> now</p>",
+			"Click here now",
+		},
+		{
+			"<p>Click <span><b>here</b></span> now</p>",
+			"Click here now",
+		},
+		{
+			"<p>Click <b>here</b></p>",
+			"Click here.",
+		},
+		{
+			"<b>Title</b><p>Body</p>",
+			"Title.\n\nBody",
+		},
+		{
+			"<p>Click <b>here</b><br>Next</p>",
+			"Click here.\nNext",
+		},
+	}
+
+	for _, testCase := range textOnlyTestCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{TextOnly: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestEmphasis(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<em>Test</em>",
+			"_Test_",
+		},
+		{
+			"\t<i>Test</i> ",
+			"_Test_",
+		},
+		{
+			"<em>Test</em> <i>Test</i>",
+			"_Test_ _Test_",
+		},
+		{
+			"<b>Bold <em>and emphasized</em></b>",
+			"*Bold _and emphasized_*",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString("<p><i>Test</i></p>", "Test.", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	if msg, err := wantString("<p>Click <em>here</em> now</p>", "Click here now", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestStrikethrough(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<del>Test</del>",
+			"~~Test~~",
+		},
+		{
+			"<s> Test </s> <strike>Test</strike>",
+			"~~Test~~ ~~Test~~",
+		},
+		{
+			"<p>Price: <del>$10</del> $5</p>",
+			"Price: ~~$10~~ $5",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString("<p>Price: <del>$10</del> $5</p>", "Price: $10 $5", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestInsertedText(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<ins>Test</ins>",
+			"[+Test+]",
+			Options{},
+		},
+		{
+			"<p>Price: <del>$10</del> <ins>$5</ins></p>",
+			"Price: ~~$10~~ [+$5+]",
+			Options{},
+		},
+		{
+			"<p>Price: <del>$10</del> <ins>$5</ins></p>",
+			"Price: ~~$10~~ ++$5++",
+			Options{Markdown: true},
+		},
+		{
+			"<p>Price: <del>$10</del> <ins>$5</ins></p>",
+			"Price: $10 $5",
+			Options{TextOnly: true},
+		},
+		{
+			"<p>Price: <ins></ins>$5</p>",
+			"Price: $5",
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestCite(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<p>As told in <cite>The Hobbit</cite> by Tolkien</p>",
+			"As told in _The Hobbit_ by Tolkien",
+			Options{},
+		},
+		{
+			"<p>As told in <cite>The Hobbit</cite> by Tolkien</p>",
+			"As told in _The Hobbit_ by Tolkien",
+			Options{Markdown: true},
+		},
+		{
+			"<p>As told in <cite>The Hobbit</cite> by Tolkien</p>",
+			"As told in The Hobbit by Tolkien",
+			Options{TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestClassStyleMap(t *testing.T) {
+	classStyleMap := map[string]string{"bold": "**", "highlight": "=="}
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<p>Some <span class="bold">important</span> text</p>`,
+			"Some important text",
+			Options{},
+		},
+		{
+			`<p>Some <span class="bold">important</span> text</p>`,
+			"Some **important** text",
+			Options{ClassStyleMap: classStyleMap},
+		},
+		{
+			`<p>Some <span class="big highlight bold">important</span> <span class="other">plain</span> text</p>`,
+			"Some ==important== plain text",
+			Options{ClassStyleMap: classStyleMap},
+		},
+		{
+			`<p>Some <span class="bold">important</span> text</p>`,
+			"Some important text",
+			Options{ClassStyleMap: classStyleMap, TextOnly: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDefinitionTerms(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<p>A <dfn>validator</dfn> is a program that checks for errors.</p>",
+			"A _validator_ is a program that checks for errors.",
+			Options{},
+		},
+		{
+			"<p>A <dfn>validator</dfn> is a program that checks for errors.</p>",
+			"A validator is a program that checks for errors.",
+			Options{TextOnly: true},
+		},
+		{
+			"<p>A <dfn>validator</dfn> is a program that checks for errors.</p>",
+			"A **validator** is a program that checks for errors.",
+			Options{NodeHandlers: map[atom.Atom]NodeHandler{
+				atom.Dfn: func(node *html.Node, content string) (string, error) {
+					return "**" + content + "**", nil
+				},
+			}},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestMark(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			"<p>Some <mark>highlighted</mark> text</p>",
+			"Some ==highlighted== text",
+			Options{},
+		},
+		{
+			"<p>Some <mark>highlighted</mark> text</p>",
+			"Some !!highlighted!! text",
+			Options{MarkDelimiter: "!!"},
+		},
+		{
+			"<p>Some <mark>highlighted</mark> text</p>",
+			"Some highlighted text",
+			Options{TextOnly: true},
+		},
+		{
+			"<mark></mark>",
+			"",
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestSupSub(t *testing.T) {
+	testCases := []struct {
+		input         string
+		asciiOutput   string
+		unicodeOutput string
+	}{
+		{
+			"E = mc<sup>2</sup>",
+			"E = mc^2",
+			"E = mc²",
+		},
+		{
+			"CO<sub>2</sub> emissions",
+			"CO_2 emissions",
+			"CO₂ emissions",
+		},
+		{
+			"x<sup>n+1</sup>",
+			"x^(n+1)",
+			"xⁿ⁺¹",
+		},
+		{
+			"Footnote<sup>a</sup>",
+			"Footnote^a",
+			"Footnote^a",
+		},
+		{
+			"x<sub>max</sub>",
+			"x_(max)",
+			"xₘₐₓ",
+		},
+		{
+			"x<sup></sup>",
+			"x",
+			"x",
+		},
+		{
+			"H<sub>2</sub>O",
+			"H_2O",
+			"H₂O",
+		},
+		{
+			"x<sup>2</sup>y",
+			"x^2y",
+			"x²y",
+		},
+		{
+			"x<sup>2</sup> <b>y</b>",
+			"x^2 *y*",
+			"x² *y*",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.asciiOutput); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.unicodeOutput, Options{UnicodeSupSub: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	// Text only output keeps the script text within its word.
+	textOnlyCases := []struct {
+		input  string
+		output string
+	}{
+		{"H<sub>2</sub>O", "H2O"},
+		{"E = mc<sup>2</sup>", "E = mc2"},
+		{"x<sup>n+1</sup> and y", "xn+1 and y"},
+		{"x<sup>2</sup> <b>y</b>", "x2 y."},
+	}
+	for _, testCase := range textOnlyCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{TextOnly: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+	if msg, err := wantString("H<sub>2</sub>O", "H₂O", Options{TextOnly: true, UnicodeSupSub: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestRenderIframes(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<p>Watch this:</p><iframe src="https://www.youtube.com/embed/xyz" title="Demo video"></iframe><p>Bye</p>`,
+			"Watch this:\n\nBye",
+			Options{},
+		},
+		{
+			`<p>Watch this:</p><iframe src="https://www.youtube.com/embed/xyz" title="Demo video"></iframe><p>Bye</p>`,
+			"Watch this:\n\n[embedded content: Demo video ( https://www.youtube.com/embed/xyz )]\n\nBye",
+			Options{RenderIframes: true},
+		},
+		{
+			`Watch this: <iframe src="https://www.youtube.com/embed/xyz"></iframe>`,
+			"Watch this:\n[embedded content: https://www.youtube.com/embed/xyz]",
+			Options{RenderIframes: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestRenderMedia(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<video controls><source src="movie.webm" type="video/webm"><source src="movie.mp4" type="video/mp4"><track kind="captions" src="movie.vtt">Your browser does not support video.</video>`,
+			"Your browser does not support video.",
+			Options{},
+		},
+		{
+			`<video controls><source src="movie.webm" type="video/webm"><source src="movie.mp4" type="video/mp4"><track kind="captions" src="movie.vtt">Your browser does not support video.</video>`,
+			"[video: movie.webm, captions: movie.vtt]",
+			Options{RenderMedia: true},
+		},
+		{
+			`<p>Listen: <audio src="song.mp3" controls></audio></p><p>Bye</p>`,
+			"Listen:\n[audio: song.mp3]\n\nBye",
+			Options{RenderMedia: true},
+		},
+		{
+			`<audio></audio>`,
+			"[audio]",
+			Options{RenderMedia: true},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestFormControls(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
+		{
+			`<form>Ready? <button type="submit"> Submit </button></form>`,
+			"Ready? [ Submit ]",
+			Options{},
+		},
+		{
+			`<form>Ready? <input type="submit" value="Send"></form>`,
+			"Ready?",
+			Options{},
+		},
+		{
+			`<form>Ready? <input type="submit" value="Send"></form>`,
+			"Ready? [ Send ]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<form><input type="text" placeholder="Your email"><input type="hidden" value="token"><button>Subscribe</button></form>`,
+			"[ Your email ] [ Subscribe ]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<form><input type="text" placeholder="Your email"><button>Subscribe</button></form>`,
+			"Your email Subscribe",
+			Options{RenderFormControls: true, TextOnly: true},
+		},
+		{
+			`<form><input type="text" value="jane"><input type="password" value="hunter2"><input type="file" value="c:\secret.txt"></form>`,
+			"[ jane ]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<form><input type="checkbox" name="news" value="yes" checked> News<br><input type="checkbox" name="ads" value="yes"> Ads</form>`,
+			"[x] News\n[ ] Ads",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<form><input type="radio" name="size" value="s"> Small <input type="RADIO" name="size" value="l" checked> Large</form>`,
+			"( ) Small (x) Large",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<form><input type="checkbox" value="yes" checked> News</form>`,
+			"News",
+			Options{RenderFormControls: true, TextOnly: true},
+		},
+		{
+			`<p>Size: <select><option>Small</option><option selected>Medium</option><option>Large</option></select></p>`,
+			"Size: [ Medium ]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<p>Size: <select><optgroup label="Sizes"><option value="s">Small</option><option label="Medium">M</option></optgroup></select></p>`,
+			"Size: [ Small ]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<p>Size: <select><option>Small</option><option selected>Medium</option></select></p>`,
+			"Size:\n\n* Small\n* Medium",
+			Options{RenderFormControls: true, ListSelectOptions: true},
+		},
+		{
+			`<p>Upload: <progress value="7" max="10">7 of 10</progress></p>`,
+			"Upload: [progress: 70%]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<p>Upload: <progress value="0.25">25%</progress></p>`,
+			"Upload: 25%",
+			Options{RenderFormControls: true, TextOnly: true},
+		},
+		{
+			`<p>Upload: <progress>in progress</progress></p>`,
+			"Upload: in progress",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<p>Disk: <meter min="0" max="200" value="150">150 GB used</meter></p>`,
+			"Disk: [meter: 75%]",
+			Options{RenderFormControls: true},
+		},
+		{
+			`<p>Disk: <meter min="0" max="200" value="150">150 GB used</meter></p>`,
+			"Disk: 150 GB used",
+			Options{},
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestAbbreviations(t *testing.T) {
+	testCases := []struct {
+		input          string
+		output         string
+		expandedOutput string
+	}{
+		{
+			`<abbr title="HyperText Markup Language">HTML</abbr>`,
+			"HTML",
+			"HTML (HyperText Markup Language)",
+		},
+		{
+			`<p>Written in <abbr title=" HyperText Markup Language ">HTML</abbr> and CSS</p>`,
+			"Written in HTML and CSS",
+			"Written in HTML (HyperText Markup Language) and CSS",
+		},
+		{
+			`<abbr>HTML</abbr>`,
+			"HTML",
+			"HTML",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.expandedOutput, Options{ExpandAbbreviations: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTimeDatetime(t *testing.T) {
+	testCases := []struct {
+		input          string
+		output         string
+		datetimeOutput string
+	}{
+		{
+			`<time datetime="2024-01-01">New Year</time>`,
+			"New Year",
+			"New Year (2024-01-01)",
+		},
+		{
+			`<p>Posted on <time datetime=" 2024-01-01T10:00 ">Monday</time> by me</p>`,
+			"Posted on Monday by me",
+			"Posted on Monday (2024-01-01T10:00) by me",
+		},
+		{
+			`<time datetime="2024-01-01">2024-01-01</time>`,
+			"2024-01-01",
+			"2024-01-01",
+		},
+		{
+			`<time>10:00</time>`,
+			"10:00",
+			"10:00",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.datetimeOutput, Options{ShowTimeDatetime: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestInlineQuotes(t *testing.T) {
+	testCases := []struct {
+		input         string
+		output        string
+		unicodeOutput string
+	}{
+		{
+			"<q>Test</q>",
+			`"Test"`,
+			"“Test”",
+		},
+		{
+			"<p>He said <q> hello </q> twice</p>",
+			`He said "hello" twice`,
+			"He said “hello” twice",
+		},
+		{
+			"<q>She said <b><q>hi</q></b></q>",
+			`"She said *'hi'*"`,
+			"“She said *‘hi’*”",
+		},
+		{
+			"<q>She said <q>hi <q>there</q></q></q>",
+			`"She said 'hi "there"'"`,
+			"“She said ‘hi “there”’”",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.unicodeOutput, Options{UnicodeQuotes: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestInlineCode(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<code>fmt.Println</code>",
+			"`fmt.Println`",
+		},
+		{
+			"<p>Call <code>  os.Exit(1) </code> to stop</p>",
+			"Call `os.Exit(1)` to stop",
+		},
+		{
+			"<code></code>",
+			"",
+		},
+		{
+			"<pre><code>if a  {\n\treturn\n}</code></pre>",
+			"if a  {\n\treturn\n}",
+		},
+		{
+			"<pre><b><code>z</code></b></pre>",
+			"z",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString("<p>Call <code>os.Exit</code></p>", "Call os.Exit", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestFencedCodeBlocks(t *testing.T) {
+	testCases := []struct {
+		input          string
+		output         string
+		markdownOutput string
+	}{
+		{
+			"<pre><code class=\"language-go\">func main() {\n\tfmt.Println(\"hi\")\n}</code></pre>",
+			"func main() {\n\tfmt.Println(\"hi\")\n}",
+			"```go\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n```",
+		},
+		{
+			"<p>Run:</p><pre class=\"lang-sh\">\n  <code>make test\n</code>\n</pre><p>Done</p>",
+			"Run:\n\n  make test\n\nDone",
+			"Run:\n\n```sh\nmake test\n```\n\nDone",
+		},
+		{
+			"<pre><code>a  b</code></pre>",
+			"a  b",
+			"```\na  b\n```",
+		},
+		{
+			"<pre>Output: <code>a  b</code></pre>",
+			"Output: a  b",
+			"Output: a  b",
+		},
+		// Link targets are still spaced from the text.
+		{
+			"<pre>see <a href=\"http://example.com/\">link</a> here</pre>",
+			"see link ( http://example.com/ ) here",
+			"see link ( http://example.com/ ) here",
+		},
+		// Syntax highlighting markup is rendered verbatim.
+		{
+			"<pre><code class=\"language-go\">func  <em>main</em>()</code></pre>",
+			"func  main()",
+			"```go\nfunc  main()\n```",
+		},
+		{
+			"<pre><code class=\"language-go\"><span class=\"k\">func</span> <b class=\"nf\">main</b>() {\n\t<s>x</s><span style=\"font-style: italic\">++</span>\n}</code></pre>",
+			"func main() {\n\tx++\n}",
+			"```go\nfunc main() {\n\tx++\n}\n```",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.markdownOutput, Options{Markdown: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestAddress(t *testing.T) {
+	testCases := []struct {
+		input          string
+		output         string
+		markdownOutput string
+	}{
+		{
+			"<address>John Doe</address>",
+			"John Doe",
+			"_John Doe_",
+		},
+		{
+			"Contact:<address>\n  John Doe<br>\n  Street 1\n</address>Thanks",
+			"Contact:\n\nJohn Doe\nStreet 1\n\nThanks",
+			"Contact:\n\n_John Doe\nStreet 1_\n\nThanks",
+		},
+		{
+			"<address> </address>",
+			"",
+			"",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+
+		if msg, err := wantString(testCase.input, testCase.markdownOutput, Options{Markdown: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestDiv(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<div>Test</div>",
+			"Test",
+		},
+		{
+			"\t<div>Test</div> ",
+			"Test",
+		},
+		{
+			"<div>Test line 1<div>Test 2</div></div>",
+			"Test line 1\nTest 2",
+		},
+		{
+			"Test 1<div>Test 2</div> <div>Test 3</div>Test 4",
+			"Test 1\nTest 2\nTest 3\nTest 4",
+		},
+		{
+			"Test 1<div>&nbsp;Test 2&nbsp;</div>",
+			"Test 1\nTest 2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+}
+
+func TestHorizontalRules(t *testing.T) {
+	divider := strings.Repeat("-", 74)
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<hr>",
+			divider,
+		},
+		{
+			"Test 1<hr/>Test 2",
+			"Test 1\n\n" + divider + "\n\nTest 2",
+		},
+		{
+			"<p>Test 1</p><hr><p>Test 2</p>",
+			"Test 1\n\n" + divider + "\n\nTest 2",
+		},
+		{
+			"<blockquote>Test 1<hr>Test 2</blockquote>",
+			"> \n> Test 1\n> \n> " + divider[2:] + "\n> \n> Test 2",
+		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	if msg, err := wantString("Test 1<hr>Test 2", "Test 1\n\nTest 2", Options{TextOnly: true}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+
+	// Dividers keep a minimum length when the prefix leaves no room.
+	if msg, err := wantString("<blockquote><hr></blockquote>", "> \n> \n> \n> ---\n> \n>", Options{LineWidth: 1}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	nested := strings.Repeat("<blockquote>", 80) + "<hr>" + strings.Repeat("</blockquote>", 80)
+	if text, err := FromString(nested); err != nil {
+		t.Error(err)
+	} else if !strings.Contains(text, strings.Repeat(">", 80)+" ---\n") {
+		t.Errorf("expected a minimum length divider in deeply nested blockquotes, got %q", text)
+	}
+}
+
+func TestBlockquotes(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
+		{
+			"<div>level 0<blockquote>level 1<br><blockquote>level 2</blockquote>level 1</blockquote><div>level 0</div></div>",
+			"level 0\n> \n> level 1\n> \n>> level 2\n> \n> level 1\n\nlevel 0",
+		},
+		{
+			"<blockquote>Test</blockquote>Test",
+			"> \n> Test\n\nTest",
+		},
+		{
+			"\t<blockquote> \nTest<br></blockquote> ",
+			"> \n> Test\n>",
+		},
+		{
+			"\t<blockquote> \nTest line 1<br>Test 2</blockquote> ",
+			"> \n> Test line 1\n> Test 2",
+		},
+		{
+			"<blockquote>Test</blockquote> <blockquote>Test</blockquote> Other Test",
+			"> \n> Test\n\n> \n> Test\n\nOther Test",
+		},
+		{
+			"<blockquote>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse labore aute quis commodo non sit dolore officia Excepteur cillum amet cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor irure do</blockquote>",
+			"> \n> Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad\n> sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat\n> voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse\n> labore aute quis commodo non sit dolore officia Excepteur cillum amet\n> cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor\n> irure do",
+		},
+		{
+			"<blockquote>Lorem<b>ipsum</b><b>Commodo</b><b>id</b><b>consectetur</b><b>pariatur</b><b>ea</b><b>occaecat</b><b>minim</b><b>aliqua</b><b>ad</b><b>sit</b><b>consequat</b><b>quis</b><b>ex</b><b>commodo</b><b>Duis</b><b>incididunt</b><b>eu</b><b>mollit</b><b>consectetur</b><b>fugiat</b><b>voluptate</b><b>dolore</b><b>in</b><b>pariatur</b><b>in</b><b>commodo</b><b>occaecat</b><b>Ut</b><b>occaecat</b><b>velit</b><b>esse</b><b>labore</b><b>aute</b><b>quis</b><b>commodo</b><b>non</b><b>sit</b><b>dolore</b><b>officia</b><b>Excepteur</b><b>cillum</b><b>amet</b><b>cupidatat</b><b>culpa</b><b>velit</b><b>labore</b><b>ullamco</b><b>dolore</b><b>mollit</b><b>elit</b><b>in</b><b>aliqua</b><b>dolor</b><b>irure</b><b>do</b></blockquote>",
+			"> \n> Lorem *ipsum* *Commodo* *id* *consectetur* *pariatur* *ea* *occaecat*\n> *minim* *aliqua* *ad* *sit* *consequat* *quis* *ex* *commodo* *Duis*\n> *incididunt* *eu* *mollit* *consectetur* *fugiat* *voluptate* *dolore*\n> *in* *pariatur* *in* *commodo* *occaecat* *Ut* *occaecat* *velit* *esse*\n> *labore* *aute* *quis* *commodo* *non* *sit* *dolore* *officia*\n> *Excepteur* *cillum* *amet* *cupidatat* *culpa* *velit* *labore* *ullamco*\n> *dolore* *mollit* *elit* *in* *aliqua* *dolor* *irure* *do*",
 		},
 	}
 
@@ -382,89 +3702,84 @@ func TestStrippingLists(t *testing.T) {
 			t.Log(msg)
 		}
 	}
+
 }
 
-func TestLinks(t *testing.T) {
+func TestLineWidth(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			`<a></a>`,
-			``,
-		},
-		{
-			`<a href=""></a>`,
-			``,
-		},
-		{
-			`<a href="http://example.com/"></a>`,
-			`( http://example.com/ )`,
-		},
-		{
-			`<a href="">Link</a>`,
-			`Link`,
-		},
-		{
-			`<a href="http://example.com/">Link</a>`,
-			`Link ( http://example.com/ )`,
-		},
-		{
-			`<a href="http://example.com/"><span class="a">Link</span></a>`,
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
-			`Link ( http://example.com/ )`,
-		},
-		{
-			"<a href='mailto:contact@example.org'>Contact Us</a>",
-			`Contact Us ( contact@example.org )`,
-		},
-		{
-			"<a href=\"http://example.com:80/~user?aaa=bb&amp;c=d,e,f#foo\">Link</a>",
-			`Link ( http://example.com:80/~user?aaa=bb&c=d,e,f#foo )`,
+			"<blockquote>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat voluptate dolore in pariatur</blockquote>",
+			"> \n> Lorem ipsum Commodo id consectetur\n> pariatur ea occaecat minim aliqua ad sit\n> consequat quis ex commodo Duis\n> incididunt eu mollit consectetur fugiat\n> voluptate dolore in pariatur",
 		},
 		{
-			"<a title='title' href=\"http://example.com/\">Link</a>",
-			`Link ( http://example.com/ )`,
+			"<hr>",
+			strings.Repeat("-", 40),
 		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LineWidth: 40}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestEmojiMode(t *testing.T) {
+	testCases := []struct {
+		input   string
+		output  string
+		options Options
+	}{
 		{
-			"<a href=\"   http://example.com/ \"> Link </a>",
-			`Link ( http://example.com/ )`,
+			"<p>Great job \U0001F44D see you soon \U0001F604\u2764\uFE0F</p>",
+			"Great job \U0001F44D see you soon \U0001F604\u2764\uFE0F",
+			Options{},
 		},
 		{
-			"<a href=\"http://example.com/a/\">Link A</a> <a href=\"http://example.com/b/\">Link B</a>",
-			`Link A ( http://example.com/a/ ) Link B ( http://example.com/b/ )`,
+			"<p>Great job \U0001F44D see you soon \U0001F604\u2764\uFE0F</p>",
+			"Great job see you soon",
+			Options{EmojiMode: EmojiStrip},
 		},
 		{
-			"<a href=\"%%LINK%%\">Link</a>",
-			`Link ( %%LINK%% )`,
+			"<p>Great job \U0001F44D see you soon \U0001F604\u2764\uFE0F</p>",
+			"Great job :+1: see you soon :smile::heart:",
+			Options{EmojiMode: EmojiShortcode},
 		},
 		{
-			"<a href=\"[LINK]\">Link</a>",
-			`Link ( [LINK] )`,
+			"<p>Unknown \U0001F9A9 stays</p>",
+			"Unknown \U0001F9A9 stays",
+			Options{EmojiMode: EmojiShortcode},
 		},
 		{
-			"<a href=\"{LINK}\">Link</a>",
-			`Link ( {LINK} )`,
+			"<p>Thanks \U0001F44D\U0001F3FD and \U0001F44F\U0001F3FF\uFE0F</p>",
+			"Thanks :+1: and :clap:",
+			Options{EmojiMode: EmojiShortcode},
 		},
+		// Joiners of other scripts are kept.
 		{
-			"<a href=\"[[!unsubscribe]]\">Link</a>",
-			`Link ( [[!unsubscribe]] )`,
+			"<p>\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D \U0001F468\u200D\U0001F373</p>",
+			"\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D",
+			Options{EmojiMode: EmojiStrip},
 		},
 		{
-			"<p>This is <a href=\"http://www.google.com\" >link1</a> and <a href=\"http://www.google.com\" >link2 </a> is next.</p>",
-			`This is link1 ( http://www.google.com ) and link2 ( http://www.google.com ) is next.`,
+			"<p>\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D \u2764\uFE0F</p>",
+			"\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D :heart:",
+			Options{EmojiMode: EmojiShortcode},
 		},
 		{
-			"<a href=\"http://www.google.com\" >http://www.google.com</a>",
-			`http://www.google.com`,
+			"<p>Unknown \U0001F9D1\U0001F3FD stays</p>",
+			"Unknown \U0001F9D1\U0001F3FD stays",
+			Options{EmojiMode: EmojiShortcode},
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -472,47 +3787,32 @@ func TestLinks(t *testing.T) {
 	}
 }
 
-func TestOmitLinks(t *testing.T) {
+func TestStripEmoji(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			`<a></a>`,
-			``,
-		},
-		{
-			`<a href=""></a>`,
-			``,
-		},
-		{
-			`<a href="http://example.com/"></a>`,
-			``,
-		},
-		{
-			`<a href="">Link</a>`,
-			`Link`,
-		},
-		{
-			`<a href="http://example.com/">Link</a>`,
-			`Link`,
+			"<p>Great job \U0001F44D see you soon \U0001F604\u2764\uFE0F</p>",
+			"Great job see you soon",
 		},
 		{
-			`<a href="http://example.com/"><span class="a">Link</span></a>`,
-			`Link`,
+			"<p>\u2605\u2605\u2605 Caf\u00E9 cr\u00E8me br\u00FBl\u00E9e, $4.50 (50% off!) \U0001F468\u200D\U0001F373</p>",
+			"Café crème brûlée, $4.50 (50% off!)",
 		},
 		{
-			"<a href='http://example.com/'>\n\t<span class='a'>Link</span>\n\t</a>",
-			`Link`,
+			"<p>\u00A9 2024 Example\u2122 \u2014 all rights reserved.</p>",
+			"2024 Example \u2014 all rights reserved.",
 		},
+		// Joiners of other scripts are kept.
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
-			`Example`,
+			"<p>\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D \U0001F468\u200D\U0001F373</p>",
+			"\u0915\u094D\u200D\u0937 and \u0928\u094D\u200D",
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output, Options{OmitLinks: true}); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, Options{StripEmoji: true, EmojiMode: EmojiShortcode}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
@@ -520,213 +3820,325 @@ func TestOmitLinks(t *testing.T) {
 	}
 }
 
-func TestImageAltTags(t *testing.T) {
+func TestWrapText(t *testing.T) {
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			`<img />`,
-			``,
-		},
-		{
-			`<img src="http://example.ru/hello.jpg" />`,
-			``,
+			"<p>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo</p><p>Duis incididunt eu mollit consectetur fugiat</p>",
+			"Lorem ipsum Commodo id consectetur\npariatur ea occaecat minim aliqua ad sit\nconsequat quis ex commodo\n\nDuis incididunt eu mollit consectetur\nfugiat",
 		},
 		{
-			`<img alt="Example"/>`,
-			``,
+			"<pre>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua</pre>",
+			"Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua",
 		},
 		{
-			`<img src="http://example.ru/hello.jpg" alt="Example"/>`,
-			``,
+			"<pre><b>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua</b></pre>",
+			"Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua",
 		},
-		// Images do matter if they are in a link.
+		// Wrapped lines of list items and definitions keep their indentation.
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"/></a>`,
-			`Example ( http://example.com/ )`,
+			"<ul><li>Lorem ipsum Commodo id consectetur pariatur<ul><li>Nested item with quite a few words that need wrapping</li></ul></li><li>Short</li></ul>",
+			"* Lorem ipsum Commodo id consectetur\n  pariatur\n  * Nested item with quite a few words\n    that need wrapping\n* Short",
 		},
 		{
-			`<a href="http://example.com/"><img src="http://example.ru/hello.jpg" alt="Example"></a>`,
-			`Example ( http://example.com/ )`,
+			"<dl><dt>Term</dt><dd>Definition with quite a few words that need wrapping across lines</dd></dl>",
+			"Term\n  Definition with quite a few words that\n  need wrapping across lines",
 		},
+		// Inline elements are wrapped along with the line they are on.
 		{
-			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'/></a>`,
-			`Example ( http://example.com/ )`,
+			"<p>Lorem ipsum Commodo id consectetur <b>pariatur ea occaecat minim aliqua ad sit</b> consequat</p>",
+			"Lorem ipsum Commodo id consectetur\n*pariatur ea occaecat minim aliqua ad\nsit* consequat",
 		},
 		{
-			`<a href='http://example.com/'><img src='http://example.ru/hello.jpg' alt='Example'></a>`,
-			`Example ( http://example.com/ )`,
+			"<p>Lorem ipsum Commodo id consectetur <a href=\"http://example.com/\">pariatur</a> ea occaecat</p>",
+			"Lorem ipsum Commodo id consectetur\npariatur ( http://example.com/ ) ea\noccaecat",
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LineWidth: 40, WrapText: true}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
-}
 
-func TestHeadings(t *testing.T) {
-	testCases := []struct {
+	// Table grids and heading dividers are laid out as a whole, not wrapped.
+	prettyCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"<h1>Test</h1>",
-			"****\nTest\n****",
+			"<table><tr><td>Lorem ipsum dolor sit</td><td>Commodo id consectetur</td></tr></table>",
+			"+-----------------------+------------------------+\n| Lorem ipsum dolor sit | Commodo id consectetur |\n+-----------------------+------------------------+",
 		},
 		{
-			"\t<h1>\nTest</h1> ",
-			"****\nTest\n****",
+			"<h1>A heading long enough to go past the forty column limit</h1>",
+			"************************************\nA heading long enough to go past the\nforty column limit\n************************************",
 		},
+	}
+	for _, testCase := range prettyCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LineWidth: 40, WrapText: true, PrettyTables: true}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+
+	// Paragraphs are left alone by default.
+	input := "<p>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua</p>"
+	if msg, err := wantString(input, "Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua", Options{LineWidth: 40}); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+}
+
+func TestWordBreakOpportunities(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
 		{
-			"\t<h1>\nTest line 1<br>Test 2</h1> ",
-			"***********\nTest line 1\nTest 2\n***********",
+			"super<wbr>cali<wbr>fragilistic",
+			"supercalifragilistic",
 		},
 		{
-			"<h1>Test</h1> <h1>Test</h1>",
-			"****\nTest\n****\n\n****\nTest\n****",
+			"<blockquote>Identifier: Very<wbr>LongIdentifier<wbr>Name</blockquote>",
+			"> \n> Identifier: Very\n> LongIdentifierName",
 		},
+	}
+
+	for _, testCase := range testCases {
+		if msg, err := wantString(testCase.input, testCase.output, Options{LineWidth: 20}); err != nil {
+			t.Error(err)
+		} else if len(msg) > 0 {
+			t.Log(msg)
+		}
+	}
+}
+
+func TestTextTransform(t *testing.T) {
+	testCases := []struct {
+		input  string
+		output string
+	}{
 		{
-			"<h2>Test</h2>",
-			"----\nTest\n----",
+			`<p>Hello <b>world</b></p>`,
+			"HELLO *WORLD*",
 		},
 		{
-			"<h1><a href='http://example.com/'>Test</a></h1>",
-			"****************************\nTest ( http://example.com/ )\n****************************",
+			`<a href="http://example.com/">Link</a>`,
+			"LINK ( http://example.com/ )",
 		},
 		{
-			"<h3> <span class='a'>Test </span></h3>",
-			"Test\n----",
+			`<pre>Code
+  block</pre>`,
+			"CODE\n  BLOCK",
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, Options{TextTransform: strings.ToUpper}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
 
+	redact := regexp.MustCompile(`\S+@\S+`)
+	options := Options{TextTransform: func(text string) string {
+		return redact.ReplaceAllString(text, "[redacted]")
+	}}
+	if msg, err := wantString("<p>Write to john@example.com today</p>", "Write to [redacted] today", options); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
 }
 
-func TestBold(t *testing.T) {
+func TestNodeHandlers(t *testing.T) {
+	handlers := map[atom.Atom]NodeHandler{
+		atom.A: func(node *html.Node, content string) (string, error) {
+			if getAttrVal(node, "class") == "button" {
+				return "[" + content + "]", nil
+			}
+			return content + " <" + getAttrVal(node, "href") + ">", nil
+		},
+		atom.Img: func(node *html.Node, content string) (string, error) {
+			return fmt.Sprintf("[image %sx%s]", getAttrVal(node, "width"), getAttrVal(node, "height")), nil
+		},
+	}
 	testCases := []struct {
 		input  string
 		output string
 	}{
 		{
-			"<b>Test</b>",
-			"*Test*",
-		},
-		{
-			"\t<b>Test</b> ",
-			"*Test*",
+			`<a href="http://example.com/">Link</a>`,
+			`Link <http://example.com/>`,
 		},
 		{
-			"\t<b>Test line 1<br>Test 2</b> ",
-			"*Test line 1\nTest 2*",
+			`<a class="button" href="http://example.com/">Buy <b>now</b></a>`,
+			`[Buy *now*]`,
 		},
 		{
-			"<b>Test</b> <b>Test</b>",
-			"*Test* *Test*",
+			`<p>Logo: <img src="logo.png" width="200" height="50"></p>`,
+			`Logo: [image 200x50]`,
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, Options{NodeHandlers: handlers}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
 
+	errHandler := errors.New("handler error")
+	options := Options{NodeHandlers: map[atom.Atom]NodeHandler{
+		atom.B: func(node *html.Node, content string) (string, error) {
+			return "", errHandler
+		},
+	}}
+	if _, err := FromString("<p><b>Test</b></p>", options); err != errHandler {
+		t.Fatalf("expected handler error, got %v", err)
+	}
+
+	// Handlers don't outlive the call they are passed to.
+	if msg, err := wantString(`<a href="http://example.com/">Link</a>`, `Link ( http://example.com/ )`); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
 }
 
-func TestDiv(t *testing.T) {
+func TestKeyboardAndSampleElements(t *testing.T) {
 	testCases := []struct {
-		input  string
-		output string
+		input   string
+		output  string
+		options Options
 	}{
 		{
-			"<div>Test</div>",
-			"Test",
+			"<p>Press <kbd>Ctrl</kbd> <kbd>C</kbd> to copy</p>",
+			"Press [Ctrl] [C] to copy",
+			Options{},
 		},
 		{
-			"\t<div>Test</div> ",
-			"Test",
+			"<p>The program prints <samp>File not found</samp></p>",
+			"The program prints `File not found`",
+			Options{},
 		},
 		{
-			"<div>Test line 1<div>Test 2</div></div>",
-			"Test line 1\nTest 2",
+			"<p>Let <var>x</var> be a number</p>",
+			"Let `x` be a number",
+			Options{},
 		},
 		{
-			"Test 1<div>Test 2</div> <div>Test 3</div>Test 4",
-			"Test 1\nTest 2\nTest 3\nTest 4",
+			"<p>Press <kbd>Ctrl</kbd> and set <var>x</var></p>",
+			"Press Ctrl and set x",
+			Options{TextOnly: true},
 		},
 		{
-			"Test 1<div>&nbsp;Test 2&nbsp;</div>",
-			"Test 1\nTest 2",
+			"<p>Press <kbd>Ctrl</kbd> and set <var>x</var></p>",
+			"Press <Ctrl> and set x",
+			Options{NodeHandlers: map[atom.Atom]NodeHandler{
+				atom.Kbd: func(node *html.Node, content string) (string, error) {
+					return "<" + content + ">", nil
+				},
+				atom.Var: func(node *html.Node, content string) (string, error) {
+					return content, nil
+				},
+			}},
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(testCase.input, testCase.output, testCase.options); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
+}
+
+func TestOptionsClone(t *testing.T) {
+	options := Options{
+		PrettyTablesOptions: NewPrettyTablesOptions(),
+		NodeHandlers: map[atom.Atom]NodeHandler{
+			atom.B: func(node *html.Node, content string) (string, error) {
+				return "**" + content + "**", nil
+			},
+		},
+		SkipSelectors: []string{".footer"},
+	}
+
+	clone := options.Clone()
+	clone.PrettyTablesOptions.ColumnSeparator = "!"
+	clone.NodeHandlers[atom.B] = func(node *html.Node, content string) (string, error) {
+		return content, nil
+	}
+	clone.NodeHandlers[atom.I] = func(node *html.Node, content string) (string, error) {
+		return content, nil
+	}
+	clone.SkipSelectors[0] = ".header"
+
+	if options.PrettyTablesOptions.ColumnSeparator == "!" {
+		t.Error("cloning shared PrettyTablesOptions")
+	}
+	if len(options.NodeHandlers) != 1 {
+		t.Errorf("cloning shared NodeHandlers, got %d handlers", len(options.NodeHandlers))
+	}
+	if options.SkipSelectors[0] != ".footer" {
+		t.Error("cloning shared SkipSelectors")
+	}
 
+	input := `<p><b>Test</b> <i>Test</i></p><p class="footer">Footer</p>`
+	if msg, err := wantString(input, "**Test** _Test_", options); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
+	if msg, err := wantString(input, "Test Test\n\nFooter", clone); err != nil {
+		t.Error(err)
+	} else if len(msg) > 0 {
+		t.Log(msg)
+	}
 }
 
-func TestBlockquotes(t *testing.T) {
+func TestBlockquotePrefix(t *testing.T) {
+	input := "<div>level 0<blockquote>level 1<br><blockquote>level 2</blockquote>level 1</blockquote><div>level 0</div></div>"
 	testCases := []struct {
-		input  string
+		prefix string
 		output string
 	}{
 		{
-			"<div>level 0<blockquote>level 1<br><blockquote>level 2</blockquote>level 1</blockquote><div>level 0</div></div>",
+			"",
 			"level 0\n> \n> level 1\n> \n>> level 2\n> \n> level 1\n\nlevel 0",
 		},
 		{
-			"<blockquote>Test</blockquote>Test",
-			"> \n> Test\n\nTest",
-		},
-		{
-			"\t<blockquote> \nTest<br></blockquote> ",
-			"> \n> Test\n>",
-		},
-		{
-			"\t<blockquote> \nTest line 1<br>Test 2</blockquote> ",
-			"> \n> Test line 1\n> Test 2",
-		},
-		{
-			"<blockquote>Test</blockquote> <blockquote>Test</blockquote> Other Test",
-			"> \n> Test\n\n> \n> Test\n\nOther Test",
+			"|",
+			"level 0\n| \n| level 1\n| \n|| level 2\n| \n| level 1\n\nlevel 0",
 		},
 		{
-			"<blockquote>Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse labore aute quis commodo non sit dolore officia Excepteur cillum amet cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor irure do</blockquote>",
-			"> \n> Lorem ipsum Commodo id consectetur pariatur ea occaecat minim aliqua ad\n> sit consequat quis ex commodo Duis incididunt eu mollit consectetur fugiat\n> voluptate dolore in pariatur in commodo occaecat Ut occaecat velit esse\n> labore aute quis commodo non sit dolore officia Excepteur cillum amet\n> cupidatat culpa velit labore ullamco dolore mollit elit in aliqua dolor\n> irure do",
+			"| ",
+			"level 0\n| \n| level 1\n| \n| | level 2\n| \n| level 1\n\nlevel 0",
 		},
 		{
-			"<blockquote>Lorem<b>ipsum</b><b>Commodo</b><b>id</b><b>consectetur</b><b>pariatur</b><b>ea</b><b>occaecat</b><b>minim</b><b>aliqua</b><b>ad</b><b>sit</b><b>consequat</b><b>quis</b><b>ex</b><b>commodo</b><b>Duis</b><b>incididunt</b><b>eu</b><b>mollit</b><b>consectetur</b><b>fugiat</b><b>voluptate</b><b>dolore</b><b>in</b><b>pariatur</b><b>in</b><b>commodo</b><b>occaecat</b><b>Ut</b><b>occaecat</b><b>velit</b><b>esse</b><b>labore</b><b>aute</b><b>quis</b><b>commodo</b><b>non</b><b>sit</b><b>dolore</b><b>officia</b><b>Excepteur</b><b>cillum</b><b>amet</b><b>cupidatat</b><b>culpa</b><b>velit</b><b>labore</b><b>ullamco</b><b>dolore</b><b>mollit</b><b>elit</b><b>in</b><b>aliqua</b><b>dolor</b><b>irure</b><b>do</b></blockquote>",
-			"> \n> Lorem *ipsum* *Commodo* *id* *consectetur* *pariatur* *ea* *occaecat* *minim*\n> *aliqua* *ad* *sit* *consequat* *quis* *ex* *commodo* *Duis* *incididunt* *eu*\n> *mollit* *consectetur* *fugiat* *voluptate* *dolore* *in* *pariatur* *in* *commodo*\n> *occaecat* *Ut* *occaecat* *velit* *esse* *labore* *aute* *quis* *commodo*\n> *non* *sit* *dolore* *officia* *Excepteur* *cillum* *amet* *cupidatat* *culpa*\n> *velit* *labore* *ullamco* *dolore* *mollit* *elit* *in* *aliqua* *dolor* *irure*\n> *do*",
+			"    ",
+			"level 0\n    \n    level 1\n    \n        level 2\n    \n    level 1\n\nlevel 0",
 		},
 	}
 
 	for _, testCase := range testCases {
-		if msg, err := wantString(testCase.input, testCase.output); err != nil {
+		if msg, err := wantString(input, testCase.output, Options{BlockquotePrefix: testCase.prefix}); err != nil {
 			t.Error(err)
 		} else if len(msg) > 0 {
 			t.Log(msg)
 		}
 	}
-
 }
 
 func TestIgnoreStylesScriptsHead(t *testing.T) {
@@ -774,6 +4186,18 @@ func TestIgnoreStylesScriptsHead(t *testing.T) {
 			`<html><head><title>Title</title></head><body></body></html>`,
 			"",
 		},
+		{
+			"<p>One</p>\n<script>x()</script>\n<p>Two</p>",
+			"One\n\nTwo",
+		},
+		{
+			"<div>One</div>\n<div><script>x()</script></div>\n<div>Two</div>",
+			"One\nTwo",
+		},
+		{
+			"<p>One</p><blockquote>\n<p><style>p {}</style></p>\n</blockquote><p>Two</p>",
+			"One\n\nTwo",
+		},
 	}
 
 	for _, testCase := range testCases {
@@ -958,6 +4382,46 @@ output:
 	return msg, nil
 }
 
+func BenchmarkFromString(b *testing.B) {
+	benchmarkFromString(b, 10)
+}
+
+func BenchmarkFromStringLarge(b *testing.B) {
+	benchmarkFromString(b, 300)
+}
+
+func BenchmarkManyTextNodes(b *testing.B) {
+	item := `<p>Some <b>bold</b> and <i>italic</i> text, <span>spans</span> and
+		<a href="http://example.com/">links</a> next to http://example.com/bare.</p>`
+	input := strings.Repeat(item, 2000)
+	options := Options{AutolinkBareURLs: true}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FromString(input, options); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkFromString converts the UTF-8 test document repeated n times.
+func benchmarkFromString(b *testing.B, n int) {
+	bs, err := os.ReadFile(path.Join(destPath, "utf8.html"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	input := strings.Repeat(string(bs), n)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := FromString(input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func Example() {
 	inputHTML := `
 <html>